@@ -0,0 +1,217 @@
+package nightfall
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxScanTextItems is the default maximum number of payload items sent in a single text scan request.
+	DefaultMaxScanTextItems = 50000
+	// DefaultMaxScanTextBytes is the default maximum size of the payload sent in a single text scan request. It is
+	// kept below the API's limit of 500KB to leave room for the policy.
+	DefaultMaxScanTextBytes = 480 * 1024
+	// DefaultScanTextConcurrency is the default number of requests made concurrently when a text scan is split.
+	DefaultScanTextConcurrency = 4
+
+	// payloadItemOverhead is the number of bytes taken up by the quotes and separator of a JSON-encoded payload item
+	payloadItemOverhead = 3
+	// maxEscapedRuneBytes is the largest size of a rune once escaped in a JSON string, as in \u001f
+	maxEscapedRuneBytes = 6
+)
+
+var (
+	errInvalidScanTextLimits      = errors.New("maxItems must be positive and maxBytes must fit at least one payload item")
+	errInvalidScanTextConcurrency = errors.New("scanTextConcurrency must be in range [1,100]")
+)
+
+// OptionScanTextLimits sets the maximum number of payload items and the maximum payload size in bytes of a single
+// text scan request. ScanText transparently splits payloads exceeding these limits into several requests.
+func OptionScanTextLimits(maxItems, maxBytes int) func(*Client) error {
+	return func(c *Client) error {
+		// A payload item must be able to hold at least one rune, or long items could not be split into windows
+		if maxItems <= 0 || maxBytes <= payloadItemOverhead+utf8.UTFMax {
+			return errInvalidScanTextLimits
+		}
+		c.maxScanTextItems = maxItems
+		c.maxScanTextBytes = maxBytes
+		return nil
+	}
+}
+
+// OptionScanTextConcurrency sets the number of requests made concurrently when ScanText splits a payload.
+func OptionScanTextConcurrency(scanTextConcurrency int) func(*Client) error {
+	return func(c *Client) error {
+		if scanTextConcurrency > 100 || scanTextConcurrency <= 0 {
+			return errInvalidScanTextConcurrency
+		}
+		c.scanTextConcurrency = scanTextConcurrency
+		return nil
+	}
+}
+
+// textSegment is a piece of text sent as a single payload item.
+type textSegment struct {
+	// index is the index of the request payload item the segment belongs to
+	index int
+	text  string
+	// byteOffset and codepointOffset locate the segment in its payload item, if the item was split into windows
+	byteOffset      int64
+	codepointOffset int64
+}
+
+// batchSegments groups segments into batches within the client's item count and size limits, preserving their
+// order. A segment larger than the size limit is placed in a batch by itself.
+func (c *Client) batchSegments(segments []textSegment) [][]textSegment {
+	var batches [][]textSegment
+	var batch []textSegment
+	var batchBytes int
+	for _, seg := range segments {
+		size := encodedLen(seg.text) + payloadItemOverhead
+		if len(batch) > 0 && (len(batch) >= c.maxScanTextItems || batchBytes+size > c.maxScanTextBytes) {
+			batches = append(batches, batch)
+			batch, batchBytes = nil, 0
+		}
+		batch = append(batch, seg)
+		batchBytes += size
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// encodedLen returns the size of text once encoded as a JSON string, excluding the quotes. Runes whose escaping
+// depends on the encoder are counted with the longest escape, so the result is an upper bound.
+func encodedLen(text string) int {
+	n := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		n += encodedRuneLen(r, size)
+		i += size
+	}
+	return n
+}
+
+// encodedRuneLen returns the size of the rune r, taking up size bytes of UTF-8, once encoded in a JSON string.
+func encodedRuneLen(r rune, size int) int {
+	switch {
+	case r == '"' || r == '\\' || r == '\n' || r == '\r' || r == '\t':
+		return 2
+	case r < 0x20, r == '\u2028', r == '\u2029':
+		return maxEscapedRuneBytes
+	case r == utf8.RuneError && size == 1:
+		// Invalid UTF-8 is replaced with \ufffd
+		return maxEscapedRuneBytes
+	}
+	return size
+}
+
+// scanBatches scans every batch as a separate request with the policy of the provided request, making up to
+// the client's text scan concurrency requests at a time. Responses are returned in the order of the batches.
+func (c *Client) scanBatches(ctx context.Context, request *ScanTextRequest, batches [][]textSegment) ([]*ScanTextResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	responses := make([]*ScanTextResponse, len(batches))
+	errChan := make(chan error, 1)
+	wg := &sync.WaitGroup{}
+	concurrencyChan := make(chan struct{}, c.scanTextConcurrency)
+
+batches:
+	for i, batch := range batches {
+		select {
+		case concurrencyChan <- struct{}{}:
+		case <-ctx.Done():
+			break batches
+		}
+
+		sub := *request
+		sub.Payload = make([]string, len(batch))
+		for j, seg := range batch {
+			sub.Payload[j] = seg.text
+		}
+
+		wg.Add(1)
+		go func(i int, sub *ScanTextRequest) {
+			defer func() {
+				wg.Done()
+				<-concurrencyChan
+			}()
+
+			resp, err := c.scanText(ctx, sub)
+			if err != nil {
+				// Only the first error is kept, since it is what caused the remaining requests to be cancelled
+				select {
+				case errChan <- err:
+				default:
+				}
+				cancel()
+				return
+			}
+			responses[i] = resp
+		}(i, &sub)
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+// scanSegments scans a payload that exceeds the client's limits by splitting it into several requests, then
+// reassembles a response in which findings and redacted payloads align with the original payload. Findings of
+// items that were split into windows are mapped back to their location in the item.
+func (c *Client) scanSegments(ctx context.Context, request *ScanTextRequest, segments []textSegment, batches [][]textSegment) (*ScanTextResponse, error) {
+	responses, err := c.scanBatches(ctx, request, batches)
+	if err != nil {
+		return nil, err
+	}
+
+	windowed := map[int]bool{}
+	for _, seg := range segments {
+		if seg.byteOffset > 0 {
+			windowed[seg.index] = true
+		}
+	}
+
+	result := &ScanTextResponse{Findings: make([][]*Finding, len(request.Payload))}
+	for i, batch := range batches {
+		resp := responses[i]
+		for j, seg := range batch {
+			if j < len(resp.Findings) {
+				for _, f := range resp.Findings[j] {
+					remapFinding(f, seg)
+				}
+				result.Findings[seg.index] = append(result.Findings[seg.index], resp.Findings[j]...)
+			}
+			if j < len(resp.RedactedPayload) {
+				if result.RedactedPayload == nil {
+					result.RedactedPayload = make([]string, len(request.Payload))
+				}
+				if !windowed[seg.index] {
+					result.RedactedPayload[seg.index] = resp.RedactedPayload[j]
+				} else if resp.RedactedPayload[j] != "" {
+					// Mark the item as redacted; its redacted payload is rebuilt from all of its windows below
+					result.RedactedPayload[seg.index] = request.Payload[seg.index]
+				}
+			}
+		}
+	}
+
+	for index := range windowed {
+		result.Findings[index] = mergeWindowFindings(result.Findings[index])
+		if result.RedactedPayload != nil && result.RedactedPayload[index] != "" {
+			result.RedactedPayload[index] = redactWindowed(request.Payload[index], result.Findings[index])
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,199 @@
+package nightfall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// echoScanHandler responds to text scans with one finding per payload item that contains "secret", covering the
+// whole item, and redacts the item by wrapping it in brackets.
+func echoScanHandler(calls *int32, maxItems int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		req := &ScanTextRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if maxItems > 0 && len(req.Payload) > maxItems {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		resp := &ScanTextResponse{
+			Findings:        make([][]*Finding, len(req.Payload)),
+			RedactedPayload: make([]string, len(req.Payload)),
+		}
+		for i, item := range req.Payload {
+			if strings.Contains(item, "secret") {
+				resp.Findings[i] = []*Finding{{
+					Finding:         item,
+					RedactedFinding: "[" + item + "]",
+					Location: &Location{
+						ByteRange:      &Range{Start: 0, End: int64(len(item))},
+						CodepointRange: &Range{Start: 0, End: int64(len([]rune(item)))},
+					},
+				}}
+				resp.RedactedPayload[i] = "[" + item + "]"
+			}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func TestScanTextBatching(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 3))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionScanTextLimits(3, 1024), OptionScanTextConcurrency(2))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	payload := make([]string, 10)
+	for i := range payload {
+		payload[i] = fmt.Sprintf("item %d", i)
+		if i%4 == 0 {
+			payload[i] = fmt.Sprintf("secret %d", i)
+		}
+	}
+	resp, err := client.ScanText(context.Background(), &ScanTextRequest{Payload: payload})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	if calls != 4 {
+		t.Errorf("Expected 4 requests, got %d", calls)
+	}
+	if len(resp.Findings) != len(payload) || len(resp.RedactedPayload) != len(payload) {
+		t.Fatalf("Expected %d findings and redacted payloads, got %d and %d", len(payload), len(resp.Findings), len(resp.RedactedPayload))
+	}
+	for i, item := range payload {
+		if i%4 != 0 {
+			if len(resp.Findings[i]) != 0 || resp.RedactedPayload[i] != "" {
+				t.Errorf("Expected no findings for item %d", i)
+			}
+			continue
+		}
+		if len(resp.Findings[i]) != 1 || resp.Findings[i][0].Finding != item {
+			t.Errorf("Findings for item %d not aligned: %v", i, resp.Findings[i])
+		}
+		if resp.RedactedPayload[i] != "["+item+"]" {
+			t.Errorf("Redacted payload for item %d not aligned: %q", i, resp.RedactedPayload[i])
+		}
+	}
+}
+
+func TestBatchSegments(t *testing.T) {
+	client, err := NewClient(OptionAPIKey("some key"), OptionScanTextLimits(100, 20))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	segments := []textSegment{
+		{index: 0, text: "12345"},
+		{index: 1, text: "12345"},
+		{index: 2, text: "123456789012345678901234567890"},
+		{index: 3, text: "12345"},
+	}
+	batches := client.batchSegments(segments)
+	expSizes := []int{2, 1, 1}
+	if len(batches) != len(expSizes) {
+		t.Fatalf("Expected %d batches, got %d", len(expSizes), len(batches))
+	}
+	for i, exp := range expSizes {
+		if len(batches[i]) != exp {
+			t.Errorf("Expected batch %d to have %d segments, got %d", i, exp, len(batches[i]))
+		}
+	}
+}
+
+func TestScanTextBatchingEscaped(t *testing.T) {
+	const maxBytes = 400
+	var calls int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var req struct {
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// The brackets of the payload array replace the separator after the last item
+		if len(req.Payload)-1 > maxBytes {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		var payload []string
+		_ = json.Unmarshal(req.Payload, &payload)
+		_ = json.NewEncoder(w).Encode(&ScanTextResponse{Findings: make([][]*Finding, len(payload))})
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionScanTextLimits(100, maxBytes))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	payload := []string{strings.Repeat("\x01", 50), strings.Repeat("\"", 50), strings.Repeat("\x01", 100), "item"}
+	resp, err := client.ScanText(context.Background(), &ScanTextRequest{Payload: payload})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if len(resp.Findings) != len(payload) {
+		t.Errorf("Expected %d findings, got %d", len(payload), len(resp.Findings))
+	}
+	if calls < 3 {
+		t.Errorf("Expected escaped items to be split across requests, got %d requests", calls)
+	}
+}
+
+func TestEncodedLen(t *testing.T) {
+	for _, text := range []string{"", "item", "aé漢😀", `"quoted" \ path`, "line\nbreak\ttab\r", "\x00\x01\x1f", "<&>", "\u2028\u2029"} {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(text); err != nil {
+			t.Fatal(err)
+		}
+		// Exclude the quotes and the trailing newline
+		if got, expected := encodedLen(text), buf.Len()-3; got != expected {
+			t.Errorf("encodedLen(%q) = %d, expected %d", text, got, expected)
+		}
+	}
+	if got := encodedLen("\xff"); got != maxEscapedRuneBytes {
+		t.Errorf("Expected invalid UTF-8 to count as an escaped replacement character, got %d", got)
+	}
+}
+
+func TestScanTextBatchingError(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"findings":[[]]}`))
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionScanTextLimits(1, 1024), OptionScanTextConcurrency(1))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	_, err = client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"a", "b", "c", "d"}})
+	if err == nil {
+		t.Fatal("Did not get expected error")
+	}
+	if calls != 2 {
+		t.Errorf("Expected remaining batches to be skipped after an error, got %d requests", calls)
+	}
+}
@@ -0,0 +1,190 @@
+package nightfall
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCircuitFailureThreshold = 5
+	DefaultCircuitCooldown         = 30 * time.Second
+	DefaultCircuitHalfOpenRequests = 1
+)
+
+var errInvalidCircuitBreaker = errors.New("circuit breaker threshold, cooldown and half-open requests must not be negative")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed is the normal state, in which all requests are made.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen is the state after repeated failures, in which requests fail immediately with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen is the state after the cooldown, in which a limited number of trial requests are made to
+	// decide whether to close or re-open the circuit.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerConfig configures the circuit breaker of a Client. Zero values are replaced by defaults.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests after which the circuit opens. Server errors,
+	// timeouts and network errors count as failures; client errors and rate limiting do not, since they show that
+	// the API is up.
+	FailureThreshold int
+	// Cooldown is how long the circuit stays open before allowing trial requests.
+	Cooldown time.Duration
+	// HalfOpenRequests is the number of trial requests allowed while half-open. The circuit closes once all of
+	// them succeed, and re-opens as soon as one fails.
+	HalfOpenRequests int
+	// OnStateChange, if set, is called whenever the circuit changes state, for example to switch callers to a
+	// fallback path while the circuit is open. It is called synchronously, so it should return quickly.
+	OnStateChange func(from, to CircuitState)
+}
+
+// OptionCircuitBreaker enables a circuit breaker in the Nightfall client, so that requests fail fast with
+// ErrCircuitOpen instead of waiting on retries while the Nightfall API is degraded.
+func OptionCircuitBreaker(config CircuitBreakerConfig) func(*Client) error {
+	return func(c *Client) error {
+		if config.FailureThreshold < 0 || config.Cooldown < 0 || config.HalfOpenRequests < 0 {
+			return errInvalidCircuitBreaker
+		}
+		if config.FailureThreshold == 0 {
+			config.FailureThreshold = DefaultCircuitFailureThreshold
+		}
+		if config.Cooldown == 0 {
+			config.Cooldown = DefaultCircuitCooldown
+		}
+		if config.HalfOpenRequests == 0 {
+			config.HalfOpenRequests = DefaultCircuitHalfOpenRequests
+		}
+		c.breaker = &circuitBreaker{config: config}
+		return nil
+	}
+}
+
+// CircuitState returns the current state of the client's circuit breaker. It is always CircuitClosed if no circuit
+// breaker is configured.
+func (c *Client) CircuitState() CircuitState {
+	if c.breaker == nil {
+		return CircuitClosed
+	}
+	return c.breaker.currentState()
+}
+
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	// trials and successes count the trial requests started and succeeded while half-open
+	trials    int
+	successes int
+}
+
+func (b *circuitBreaker) currentState() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow reports whether a request may be made, returning ErrCircuitOpen if not.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	from := b.state
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.config.Cooldown {
+		b.setStateLocked(CircuitHalfOpen)
+	}
+	var err error
+	switch {
+	case b.state == CircuitOpen:
+		err = ErrCircuitOpen
+	case b.state == CircuitHalfOpen && b.trials >= b.config.HalfOpenRequests:
+		err = ErrCircuitOpen
+	case b.state == CircuitHalfOpen:
+		b.trials++
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+	return err
+}
+
+// record updates the breaker with the outcome of a request that was allowed.
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case CircuitClosed:
+		if !failed {
+			b.failures = 0
+		} else if b.failures++; b.failures >= b.config.FailureThreshold {
+			b.setStateLocked(CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		if failed {
+			b.setStateLocked(CircuitOpen)
+		} else if b.successes++; b.successes >= b.config.HalfOpenRequests {
+			b.setStateLocked(CircuitClosed)
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+// cancel releases a request that was allowed but whose outcome says nothing about the health of the API, such as
+// one cancelled by the caller.
+func (b *circuitBreaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+func (b *circuitBreaker) setStateLocked(state CircuitState) {
+	b.state = state
+	b.failures = 0
+	b.trials = 0
+	b.successes = 0
+	if state == CircuitOpen {
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) notify(from, to CircuitState) {
+	if from != to && b.config.OnStateChange != nil {
+		b.config.OnStateChange(from, to)
+	}
+}
+
+// isCircuitFailure reports whether the outcome of an attempt indicates the Nightfall API is degraded.
+func isCircuitFailure(resp *Response, err error) bool {
+	if err == nil {
+		return false
+	}
+	if resp != nil {
+		return resp.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
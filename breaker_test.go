@@ -0,0 +1,118 @@
+package nightfall
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var healthy int32
+	var callCount int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&callCount, 1)
+		if atomic.LoadInt32(&healthy) == 1 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer s.Close()
+
+	var transitions []string
+	client, err := NewClient(
+		OptionAPIKey("some key"),
+		OptionBaseURL(s.URL),
+		OptionRetryPolicy(testRetryPolicy),
+		OptionCircuitBreaker(CircuitBreakerConfig{
+			FailureThreshold: 3,
+			Cooldown:         50 * time.Millisecond,
+			OnStateChange: func(from, to CircuitState) {
+				transitions = append(transitions, from.String()+"->"+to.String())
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	scan := func() error {
+		_, err := client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"hello"}})
+		return err
+	}
+
+	// The third failed attempt opens the circuit, so the remaining retries fail fast
+	if err := scan(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected circuit to open while retrying, got %v", err)
+	}
+	if calls := atomic.LoadInt32(&callCount); calls != 3 {
+		t.Errorf("Expected 3 calls before the circuit opened, got %d", calls)
+	}
+	if client.CircuitState() != CircuitOpen {
+		t.Fatalf("Expected circuit to be open, got %s", client.CircuitState())
+	}
+	if err := scan(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected open circuit to fail fast, got %v", err)
+	}
+	if calls := atomic.LoadInt32(&callCount); calls != 3 {
+		t.Errorf("Expected no calls while the circuit is open, got %d", calls)
+	}
+
+	// A failed trial request re-opens the circuit
+	time.Sleep(60 * time.Millisecond)
+	if err := scan(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected failed trial to re-open the circuit, got %v", err)
+	}
+	if client.CircuitState() != CircuitOpen {
+		t.Fatalf("Expected circuit to be open, got %s", client.CircuitState())
+	}
+
+	// A successful trial request closes the circuit
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(60 * time.Millisecond)
+	if err := scan(); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if client.CircuitState() != CircuitClosed {
+		t.Fatalf("Expected circuit to be closed, got %s", client.CircuitState())
+	}
+
+	expTransitions := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(expTransitions) {
+		t.Fatalf("Expected transitions %v, got %v", expTransitions, transitions)
+	}
+	for i := range expTransitions {
+		if transitions[i] != expTransitions[i] {
+			t.Fatalf("Expected transitions %v, got %v", expTransitions, transitions)
+		}
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1}))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"hello"}})
+		if err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Expected bad request error, got %v", err)
+		}
+	}
+	if client.CircuitState() != CircuitClosed {
+		t.Errorf("Expected circuit to stay closed, got %s", client.CircuitState())
+	}
+
+	if _, err := NewClient(OptionAPIKey("some key"), OptionCircuitBreaker(CircuitBreakerConfig{Cooldown: -1})); err == nil {
+		t.Error("Expected invalid circuit breaker config to be rejected")
+	}
+}
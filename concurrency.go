@@ -0,0 +1,182 @@
+package nightfall
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// adaptiveLatencyTolerance is the factor by which the latency of a chunk upload may exceed the moving average
+	// before it is treated as a sign of overload
+	adaptiveLatencyTolerance = 2.0
+	adaptiveDecreaseFactor   = 0.5
+	adaptiveLatencyWeight    = 0.2
+)
+
+// uploadSemaphore bounds the number of chunks uploaded concurrently.
+type uploadSemaphore interface {
+	acquire(ctx context.Context) error
+	release()
+}
+
+type staticSemaphore chan struct{}
+
+func (s staticSemaphore) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s staticSemaphore) release() {
+	<-s
+}
+
+// adaptiveLimiter is an uploadSemaphore whose limit follows an additive-increase/multiplicative-decrease scheme:
+// the limit grows by one for every limit's worth of successful uploads, and is halved when the API responds with
+// 429 or latency spikes, at most once per round trip.
+type adaptiveLimiter struct {
+	mu           sync.Mutex
+	limit        float64
+	max          int
+	inFlight     int
+	waiters      []chan struct{}
+	avgLatency   time.Duration
+	lastDecrease time.Time
+}
+
+func newAdaptiveLimiter(max int) *adaptiveLimiter {
+	return &adaptiveLimiter{
+		limit: 1,
+		max:   max,
+	}
+}
+
+func (l *adaptiveLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) && len(l.waiters) == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	l.waiters = append(l.waiters, ch)
+	l.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, w := range l.waiters {
+			if w == ch {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// The slot was granted concurrently with cancellation, so hand it to the next waiter
+		l.inFlight--
+		l.wakeLocked()
+		return ctx.Err()
+	}
+}
+
+func (l *adaptiveLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.wakeLocked()
+}
+
+func (l *adaptiveLimiter) wakeLocked() {
+	for l.inFlight < int(l.limit) && len(l.waiters) > 0 {
+		l.inFlight++
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	}
+}
+
+// observe adjusts the limit based on the outcome of a single upload attempt.
+func (l *adaptiveLimiter) observe(resp *Response, err error, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case resp != nil && resp.StatusCode == 429:
+		l.decreaseLocked()
+	case err != nil:
+		// Other failures say nothing about whether the API is overloaded
+		return
+	case l.avgLatency > 0 && float64(latency) > adaptiveLatencyTolerance*float64(l.avgLatency):
+		l.decreaseLocked()
+	default:
+		l.limit += 1 / l.limit
+		if l.limit > float64(l.max) {
+			l.limit = float64(l.max)
+		}
+	}
+
+	if err == nil {
+		if l.avgLatency == 0 {
+			l.avgLatency = latency
+		} else {
+			l.avgLatency = time.Duration((1-adaptiveLatencyWeight)*float64(l.avgLatency) + adaptiveLatencyWeight*float64(latency))
+		}
+	}
+	l.wakeLocked()
+}
+
+func (l *adaptiveLimiter) decreaseLocked() {
+	// Uploads that were already in flight when the limit was lowered report the same congestion, so only back off
+	// once per round trip
+	now := time.Now()
+	if now.Sub(l.lastDecrease) < l.avgLatency {
+		return
+	}
+	l.lastDecrease = now
+	l.limit *= adaptiveDecreaseFactor
+	if l.limit < 1 {
+		l.limit = 1
+	}
+}
+
+func (l *adaptiveLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// OptionAdaptiveFileUploadConcurrency makes the Nightfall client adjust the number of goroutines uploading chunks of
+// data based on feedback from the API, up to maxFileUploadConcurrency. The concurrency starts at 1, increases while
+// chunk uploads succeed, and backs off when uploads are rate limited or their latency spikes. The limit is shared by
+// all file scans made with the client; use FileUploadConcurrency to observe it.
+func OptionAdaptiveFileUploadConcurrency(maxFileUploadConcurrency int) func(*Client) error {
+	return func(c *Client) error {
+		if maxFileUploadConcurrency > 100 || maxFileUploadConcurrency <= 0 {
+			return errInvalidFileUploadConcurrency
+		}
+		c.fileUploadConcurrency = maxFileUploadConcurrency
+		c.adaptiveUploads = newAdaptiveLimiter(maxFileUploadConcurrency)
+		return nil
+	}
+}
+
+// FileUploadConcurrency returns the number of chunks the client currently uploads concurrently per file scan, or
+// across all file scans if adaptive concurrency is enabled.
+func (c *Client) FileUploadConcurrency() int {
+	if c.adaptiveUploads != nil {
+		return c.adaptiveUploads.current()
+	}
+	return c.fileUploadConcurrency
+}
+
+func (c *Client) uploadSemaphore() uploadSemaphore {
+	if c.adaptiveUploads != nil {
+		return c.adaptiveUploads
+	}
+	return make(staticSemaphore, c.fileUploadConcurrency)
+}
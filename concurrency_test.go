@@ -0,0 +1,130 @@
+package nightfall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := newAdaptiveLimiter(4)
+	if l.current() != 1 {
+		t.Fatalf("Expected initial limit of 1, got %d", l.current())
+	}
+
+	for i := 0; i < 20; i++ {
+		l.observe(&Response{StatusCode: http.StatusOK}, nil, 10*time.Millisecond)
+	}
+	if l.current() != 4 {
+		t.Fatalf("Expected limit to grow to max of 4, got %d", l.current())
+	}
+
+	l.observe(&Response{StatusCode: http.StatusTooManyRequests}, &Error{StatusCode: http.StatusTooManyRequests}, 10*time.Millisecond)
+	if l.current() != 2 {
+		t.Fatalf("Expected limit to halve to 2 on 429, got %d", l.current())
+	}
+	// A second 429 within the same round trip is not counted again
+	l.observe(&Response{StatusCode: http.StatusTooManyRequests}, &Error{StatusCode: http.StatusTooManyRequests}, 10*time.Millisecond)
+	if l.current() != 2 {
+		t.Fatalf("Expected limit to stay at 2, got %d", l.current())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	l.observe(&Response{StatusCode: http.StatusOK}, nil, time.Second)
+	if l.current() != 1 {
+		t.Fatalf("Expected limit to halve to 1 on latency spike, got %d", l.current())
+	}
+
+	l.observe(nil, errors.New("some error"), time.Millisecond)
+	if l.current() != 1 {
+		t.Fatalf("Expected limit to be unaffected by other errors, got %d", l.current())
+	}
+}
+
+func TestAdaptiveLimiterAcquire(t *testing.T) {
+	l := newAdaptiveLimiter(2)
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected acquire to block until deadline, got %v", err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		_ = l.acquire(context.Background())
+		close(acquired)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	l.release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Waiter was not woken up on release")
+	}
+	if l.inFlight != 1 || len(l.waiters) != 0 {
+		t.Errorf("Unexpected limiter state: %d in flight, %d waiters", l.inFlight, len(l.waiters))
+	}
+}
+
+func TestScanFileAdaptiveConcurrency(t *testing.T) {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	var chunkCalls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/upload", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := json.Marshal(fileUploadResponse{ID: uuid.MustParse(uuidStr), FileSizeBytes: 100, ChunkSize: 5})
+		_, _ = w.Write(b)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&chunkCalls, 1)%7 == 0 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr+"/finish", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr+"/scan", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"some scan"}`))
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionRetryPolicy(testRetryPolicy), OptionAdaptiveFileUploadConcurrency(8))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	if client.FileUploadConcurrency() != 1 {
+		t.Fatalf("Expected initial concurrency of 1, got %d", client.FileUploadConcurrency())
+	}
+
+	_, err = client.ScanFile(context.Background(), &ScanFileRequest{
+		Content:          strings.NewReader(strings.Repeat("a", 100)),
+		ContentSizeBytes: 100,
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if c := client.FileUploadConcurrency(); c < 1 || c > 8 {
+		t.Errorf("Effective concurrency %d outside of bounds", c)
+	}
+	if client.adaptiveUploads.inFlight != 0 {
+		t.Errorf("Expected all upload slots to be released, %d still in flight", client.adaptiveUploads.inFlight)
+	}
+
+	if _, err := NewClient(OptionAPIKey("some key"), OptionAdaptiveFileUploadConcurrency(101)); err == nil {
+		t.Error("Expected invalid maximum concurrency to be rejected")
+	}
+}
@@ -0,0 +1,200 @@
+package nightfall
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultCredentialPollInterval = 10 * time.Second
+
+// CredentialProvider supplies the API key used to authenticate requests. The client consults its provider before
+// every request, so implementations backed by remote secret stores should cache keys, for example by wrapping
+// them with NewCachedCredentials. Implementations must be safe for concurrent use.
+type CredentialProvider interface {
+	APIKey(ctx context.Context) (string, error)
+}
+
+// CredentialRefresher is implemented by CredentialProviders that cache keys. When the Nightfall API rejects a key
+// as unauthorized, the client calls Refresh with the rejected key, and retries the request once if the provider
+// then supplies a different key.
+type CredentialRefresher interface {
+	Refresh(ctx context.Context, rejectedKey string) error
+}
+
+// OptionCredentialProvider sets the provider of the API key used in the Nightfall client, which allows long-lived
+// clients to pick up rotated keys. It takes precedence over OptionAPIKey and the NIGHTFALL_API_KEY environment
+// variable.
+func OptionCredentialProvider(provider CredentialProvider) func(*Client) error {
+	return func(c *Client) error {
+		c.credentials = provider
+		return nil
+	}
+}
+
+// StaticCredentials returns a CredentialProvider that always supplies the provided API key.
+func StaticCredentials(apiKey string) CredentialProvider {
+	return staticCredentials(apiKey)
+}
+
+type staticCredentials string
+
+func (s staticCredentials) APIKey(context.Context) (string, error) {
+	if s == "" {
+		return "", errMissingAPIKey
+	}
+	return string(s), nil
+}
+
+// EnvCredentials returns a CredentialProvider that reads the API key from the named environment variable every
+// time it is consulted.
+func EnvCredentials(name string) CredentialProvider {
+	return envCredentials(name)
+}
+
+type envCredentials string
+
+func (e envCredentials) APIKey(context.Context) (string, error) {
+	key := strings.TrimSpace(os.Getenv(string(e)))
+	if key == "" {
+		return "", errMissingAPIKey
+	}
+	return key, nil
+}
+
+// FileCredentials is a CredentialProvider that reads the API key from a file, such as one mounted from a secrets
+// manager, and picks up changes to the file. Surrounding whitespace in the file is ignored.
+type FileCredentials struct {
+	path         string
+	pollInterval time.Duration
+
+	mu        sync.Mutex
+	key       string
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// NewFileCredentials returns a FileCredentials reading the API key from path. The file is checked for changes at
+// most once every pollInterval; if pollInterval is zero, DefaultCredentialPollInterval is used.
+func NewFileCredentials(path string, pollInterval time.Duration) *FileCredentials {
+	if pollInterval <= 0 {
+		pollInterval = DefaultCredentialPollInterval
+	}
+	return &FileCredentials{
+		path:         path,
+		pollInterval: pollInterval,
+	}
+}
+
+// APIKey implements CredentialProvider. If the file cannot be read but a key was read previously, for example
+// while the file is being replaced, the previous key is returned.
+func (f *FileCredentials) APIKey(context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.key != "" && time.Since(f.lastCheck) < f.pollInterval {
+		return f.key, nil
+	}
+	err := f.loadLocked(false)
+	if err != nil && f.key == "" {
+		return "", err
+	}
+	return f.key, nil
+}
+
+// Refresh implements CredentialRefresher by re-reading the file.
+func (f *FileCredentials) Refresh(_ context.Context, rejectedKey string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.key != rejectedKey {
+		// Another request already picked up a new key
+		return nil
+	}
+	return f.loadLocked(true)
+}
+
+func (f *FileCredentials) loadLocked(force bool) error {
+	f.lastCheck = time.Now()
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if !force && f.key != "" && fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return nil
+	}
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	key := strings.TrimSpace(string(b))
+	if key == "" {
+		return errMissingAPIKey
+	}
+	f.key = key
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+	return nil
+}
+
+// CachedCredentials is a CredentialProvider that caches the keys supplied by another provider for a fixed
+// duration, and refreshes them early when the Nightfall API rejects a key.
+type CachedCredentials struct {
+	provider CredentialProvider
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	key       string
+	fetchedAt time.Time
+}
+
+// NewCachedCredentials returns a CachedCredentials that caches keys supplied by provider for ttl.
+func NewCachedCredentials(provider CredentialProvider, ttl time.Duration) *CachedCredentials {
+	return &CachedCredentials{
+		provider: provider,
+		ttl:      ttl,
+	}
+}
+
+// APIKey implements CredentialProvider.
+func (c *CachedCredentials) APIKey(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.key != "" && time.Since(c.fetchedAt) < c.ttl {
+		return c.key, nil
+	}
+	return c.fetchLocked(ctx)
+}
+
+// Refresh implements CredentialRefresher by discarding the cached key and fetching a new one.
+func (c *CachedCredentials) Refresh(ctx context.Context, rejectedKey string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.key != rejectedKey {
+		// Another request already picked up a new key
+		return nil
+	}
+	if r, ok := c.provider.(CredentialRefresher); ok {
+		if err := r.Refresh(ctx, rejectedKey); err != nil {
+			return err
+		}
+	}
+	_, err := c.fetchLocked(ctx)
+	return err
+}
+
+func (c *CachedCredentials) fetchLocked(ctx context.Context) (string, error) {
+	key, err := c.provider.APIKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.key = key
+	c.fetchedAt = time.Now()
+	return key, nil
+}
@@ -0,0 +1,164 @@
+package nightfall
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(path, []byte("old key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFileCredentials(path, time.Millisecond)
+	key, err := f.APIKey(context.Background())
+	if err != nil || key != "old key" {
+		t.Fatalf("Expected old key, got %q, %v", key, err)
+	}
+
+	if err := os.WriteFile(path, []byte("new key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	key, err = f.APIKey(context.Background())
+	if err != nil || key != "new key" {
+		t.Fatalf("Expected rotated key, got %q, %v", key, err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	key, err = f.APIKey(context.Background())
+	if err != nil || key != "new key" {
+		t.Fatalf("Expected previous key while file is missing, got %q, %v", key, err)
+	}
+
+	if _, err := NewFileCredentials(path, 0).APIKey(context.Background()); err == nil {
+		t.Error("Expected error reading missing file")
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	t.Setenv("NIGHTFALL_TEST_KEY", "")
+	e := EnvCredentials("NIGHTFALL_TEST_KEY")
+	if _, err := e.APIKey(context.Background()); err == nil {
+		t.Error("Expected error for empty environment variable")
+	}
+
+	t.Setenv("NIGHTFALL_TEST_KEY", "some key")
+	if key, err := e.APIKey(context.Background()); err != nil || key != "some key" {
+		t.Errorf("Expected key from environment, got %q, %v", key, err)
+	}
+}
+
+type countingCredentials struct {
+	fetches int32
+	keys    []string
+}
+
+func (c *countingCredentials) APIKey(context.Context) (string, error) {
+	n := atomic.AddInt32(&c.fetches, 1)
+	if int(n) > len(c.keys) {
+		return c.keys[len(c.keys)-1], nil
+	}
+	return c.keys[n-1], nil
+}
+
+func TestCachedCredentials(t *testing.T) {
+	provider := &countingCredentials{keys: []string{"old key", "new key"}}
+	c := NewCachedCredentials(provider, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if key, err := c.APIKey(context.Background()); err != nil || key != "old key" {
+			t.Fatalf("Expected cached old key, got %q, %v", key, err)
+		}
+	}
+	if provider.fetches != 1 {
+		t.Errorf("Expected 1 fetch, got %d", provider.fetches)
+	}
+
+	if err := c.Refresh(context.Background(), "old key"); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	// Refreshing a key that was already replaced does not fetch again
+	if err := c.Refresh(context.Background(), "old key"); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if key, err := c.APIKey(context.Background()); err != nil || key != "new key" {
+		t.Fatalf("Expected refreshed key, got %q, %v", key, err)
+	}
+	if provider.fetches != 2 {
+		t.Errorf("Expected 2 fetches, got %d", provider.fetches)
+	}
+}
+
+func TestClientRefreshesCredentialsOnUnauthorized(t *testing.T) {
+	var callCount int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&callCount, 1)
+		if r.Header.Get("Authorization") != "Bearer new key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		name     string
+		provider CredentialProvider
+		expCalls int32
+		wantErr  bool
+	}{
+		{
+			name:     "refreshed",
+			provider: NewCachedCredentials(&countingCredentials{keys: []string{"old key", "new key"}}, time.Hour),
+			expCalls: 2,
+		},
+		{
+			name:     "key unchanged",
+			provider: NewCachedCredentials(&countingCredentials{keys: []string{"old key"}}, time.Hour),
+			expCalls: 1,
+			wantErr:  true,
+		},
+		{
+			name:     "not refreshable",
+			provider: StaticCredentials("old key"),
+			expCalls: 1,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&callCount, 0)
+			client, err := NewClient(OptionBaseURL(s.URL), OptionCredentialProvider(test.provider))
+			if err != nil {
+				t.Fatal("Error initializing client")
+			}
+
+			_, err = client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"hello"}})
+			if !test.wantErr && err != nil {
+				t.Errorf("Got unexpected error: %v", err)
+			}
+			if test.wantErr && err == nil {
+				t.Error("Did not get expected error")
+			}
+			if calls := atomic.LoadInt32(&callCount); calls != test.expCalls {
+				t.Errorf("Expected %d calls, got %d", test.expCalls, calls)
+			}
+		})
+	}
+}
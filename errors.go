@@ -0,0 +1,97 @@
+package nightfall
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Sentinel errors that classify failed requests. Errors returned by the Client wrap an *Error carrying the
+// details of the failed response, and can be matched against these with errors.Is.
+var (
+	// ErrUnauthorized indicates the API key is missing, invalid or lacks permission for the request.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRateLimited indicates the request was rejected because the rate limit of the API key was exceeded.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrQuotaExceeded indicates the usage quota of the Nightfall plan has been exhausted. Unlike rate limiting,
+	// retrying will not succeed until the quota resets.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrPayloadTooLarge indicates the request body or file exceeds the size allowed by the Nightfall API.
+	ErrPayloadTooLarge = errors.New("payload too large")
+	// ErrNotFound indicates the requested resource, such as an upload session, does not exist or has expired.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidPolicy indicates the Nightfall API rejected the policy, detection rules or detectors in the request.
+	ErrInvalidPolicy = errors.New("invalid policy")
+	// ErrCircuitOpen indicates the request was not made because the client's circuit breaker is open after
+	// repeated failures of the Nightfall API.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// ErrUploadFailed indicates a step of the file upload process failed. Use errors.As with *UploadError to
+	// find out which step and offset.
+	ErrUploadFailed = errors.New("upload failed")
+)
+
+// Is reports whether the error matches one of the exported sentinel errors.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests && !e.mentions("quota")
+	case ErrQuotaExceeded:
+		return e.StatusCode == http.StatusPaymentRequired ||
+			(e.StatusCode == http.StatusTooManyRequests && e.mentions("quota"))
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrPayloadTooLarge:
+		return e.StatusCode == http.StatusRequestEntityTooLarge
+	case ErrInvalidPolicy:
+		return (e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity) &&
+			(e.mentions("policy") || e.mentions("detector") || e.mentions("detection rule"))
+	default:
+		return false
+	}
+}
+
+func (e *Error) mentions(s string) bool {
+	return strings.Contains(strings.ToLower(e.Message), s) || strings.Contains(strings.ToLower(e.Description), s)
+}
+
+// UploadPhase identifies a step of the multi-step file upload process.
+type UploadPhase string
+
+const (
+	UploadPhaseInit   UploadPhase = "init"
+	UploadPhaseUpload UploadPhase = "upload"
+	UploadPhaseFinish UploadPhase = "finish"
+	UploadPhaseScan   UploadPhase = "scan"
+)
+
+// UploadError is returned when a step of the file upload process fails. It matches ErrUploadFailed with
+// errors.Is, and wraps the underlying error, which is usually an *Error.
+type UploadError struct {
+	Phase UploadPhase
+	// FileID is the ID of the upload session; it is the zero UUID if the session could not be initialized.
+	FileID uuid.UUID
+	// Offset is the byte offset of the chunk that failed to upload; it is only meaningful in UploadPhaseUpload.
+	Offset int64
+	Err    error
+}
+
+func (e *UploadError) Error() string {
+	if e.Phase == UploadPhaseUpload {
+		return fmt.Sprintf("upload failed at offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("upload failed during %s: %v", e.Phase, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrUploadFailed.
+func (e *UploadError) Is(target error) bool {
+	return target == ErrUploadFailed
+}
@@ -0,0 +1,218 @@
+package nightfall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestErrorIs(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		expErr error
+	}{
+		{
+			name:   "unauthorized",
+			status: http.StatusUnauthorized,
+			expErr: ErrUnauthorized,
+		},
+		{
+			name:   "forbidden",
+			status: http.StatusForbidden,
+			expErr: ErrUnauthorized,
+		},
+		{
+			name:   "rate limited",
+			status: http.StatusTooManyRequests,
+			body:   `{"code":42900,"message":"Too Many Requests"}`,
+			expErr: ErrRateLimited,
+		},
+		{
+			name:   "quota exceeded",
+			status: http.StatusTooManyRequests,
+			body:   `{"code":42901,"message":"Monthly quota exceeded"}`,
+			expErr: ErrQuotaExceeded,
+		},
+		{
+			name:   "not found",
+			status: http.StatusNotFound,
+			expErr: ErrNotFound,
+		},
+		{
+			name:   "payload too large",
+			status: http.StatusRequestEntityTooLarge,
+			expErr: ErrPayloadTooLarge,
+		},
+		{
+			name:   "invalid policy",
+			status: http.StatusBadRequest,
+			body:   `{"code":40000,"message":"Invalid Request","description":"policy must contain at least one detection rule"}`,
+			expErr: ErrInvalidPolicy,
+		},
+	}
+	sentinels := []error{ErrUnauthorized, ErrRateLimited, ErrQuotaExceeded, ErrNotFound, ErrPayloadTooLarge, ErrInvalidPolicy}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-Id", "some request")
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			defer s.Close()
+
+			client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionRetryPolicy(testRetryPolicy))
+			if err != nil {
+				t.Fatal("Error initializing client")
+			}
+
+			_, err = client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"hello"}})
+			for _, sentinel := range sentinels {
+				if errors.Is(err, sentinel) != (sentinel == test.expErr) {
+					t.Errorf("errors.Is(%v, %v) returned unexpected result", err, sentinel)
+				}
+			}
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Expected *Error, got %T", err)
+			}
+			if apiErr.StatusCode != test.status {
+				t.Errorf("Expected status %d, got %d", test.status, apiErr.StatusCode)
+			}
+			if apiErr.RequestID != "some request" {
+				t.Errorf("Expected request ID to be set, got %q", apiErr.RequestID)
+			}
+		})
+	}
+}
+
+func TestErrorRetries(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionRetryPolicy(testRetryPolicy))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	err = client.do(context.Background(), requestParams{method: http.MethodPost, url: s.URL}, nil)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected *Error, got %T", err)
+	}
+	if apiErr.Retries != DefaultRetryCount {
+		t.Errorf("Expected %d retries, got %d", DefaultRetryCount, apiErr.Retries)
+	}
+}
+
+func TestUploadError(t *testing.T) {
+	reqUUID := uuid.MustParse("430d42aa-1e1f-405d-8799-7f5f26486a0d")
+	tests := []struct {
+		name      string
+		handlers  map[string]http.HandlerFunc
+		expPhase  UploadPhase
+		expOffset int64
+	}{
+		{
+			name: "init",
+			handlers: map[string]http.HandlerFunc{
+				"/v3/upload": func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusUnauthorized)
+				},
+			},
+			expPhase: UploadPhaseInit,
+		},
+		{
+			name: "chunk",
+			handlers: map[string]http.HandlerFunc{
+				"/v3/upload/" + reqUUID.String(): func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("X-Upload-Offset") == "5" {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					w.WriteHeader(http.StatusOK)
+				},
+			},
+			expPhase:  UploadPhaseUpload,
+			expOffset: 5,
+		},
+		{
+			name: "finish",
+			handlers: map[string]http.HandlerFunc{
+				"/v3/upload/" + reqUUID.String(): func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				},
+				"/v3/upload/" + reqUUID.String() + "/finish": func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusUnauthorized)
+				},
+			},
+			expPhase: UploadPhaseFinish,
+		},
+		{
+			name: "scan",
+			handlers: map[string]http.HandlerFunc{
+				"/v3/upload/" + reqUUID.String(): func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				},
+				"/v3/upload/" + reqUUID.String() + "/finish": func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				},
+				"/v3/upload/" + reqUUID.String() + "/scan": func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusUnauthorized)
+				},
+			},
+			expPhase: UploadPhaseScan,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			if _, ok := test.handlers["/v3/upload"]; !ok {
+				mux.HandleFunc("/v3/upload", func(w http.ResponseWriter, r *http.Request) {
+					b, _ := json.Marshal(fileUploadResponse{ID: reqUUID, FileSizeBytes: 15, ChunkSize: 5})
+					_, _ = w.Write(b)
+				})
+			}
+			for pattern, handler := range test.handlers {
+				mux.HandleFunc(pattern, handler)
+			}
+			s := httptest.NewServer(mux)
+			defer s.Close()
+
+			client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+			if err != nil {
+				t.Fatal("Error initializing client")
+			}
+
+			_, err = client.ScanFile(context.Background(), &ScanFileRequest{
+				Content:          strings.NewReader("4242 4242 4242 4242"),
+				ContentSizeBytes: 15,
+			})
+			if !errors.Is(err, ErrUploadFailed) || !errors.Is(err, ErrUnauthorized) {
+				t.Fatalf("Expected unauthorized upload failure, got %v", err)
+			}
+			var uploadErr *UploadError
+			if !errors.As(err, &uploadErr) {
+				t.Fatalf("Expected *UploadError, got %T", err)
+			}
+			if uploadErr.Phase != test.expPhase {
+				t.Errorf("Expected phase %s, got %s", test.expPhase, uploadErr.Phase)
+			}
+			if uploadErr.Offset != test.expOffset {
+				t.Errorf("Expected offset %d, got %d", test.expOffset, uploadErr.Offset)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package nightfall
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExpvarInstrumentation is an Instrumentation that publishes counters through the expvar package, where they are
+// served as JSON by the /debug/vars handler and can be scraped into other monitoring systems. The published map
+// contains the following counters, where per-operation counters are suffixed with the Operation, e.g.
+// "requests.scan_text":
+//   - requests.<operation>: number of request attempts
+//   - errors.<operation>: number of failed request attempts
+//   - latency_ms.<operation>: total latency of request attempts in milliseconds
+//   - retries.<operation>: number of retries
+//   - chunks_uploaded: number of file chunks uploaded
+//   - bytes_uploaded: number of file bytes uploaded
+//   - findings: number of findings returned by text scans
+type ExpvarInstrumentation struct {
+	NopInstrumentation
+	vars *expvar.Map
+}
+
+var errExpvarNameTaken = errors.New("name is already published as an expvar that is not a map")
+
+// expvarMu serializes the publishing of maps, since expvar panics if a name is published twice.
+var expvarMu sync.Mutex
+
+// NewExpvarInstrumentation returns an ExpvarInstrumentation publishing its counters under the provided name.
+// Clients created with the same name share their counters. It returns an error if the name is already published
+// as an expvar that is not a map.
+func NewExpvarInstrumentation(name string) (*ExpvarInstrumentation, error) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	published := expvar.Get(name)
+	if published == nil {
+		return &ExpvarInstrumentation{vars: expvar.NewMap(name)}, nil
+	}
+	vars, ok := published.(*expvar.Map)
+	if !ok {
+		return nil, errExpvarNameTaken
+	}
+	return &ExpvarInstrumentation{vars: vars}, nil
+}
+
+// Map returns the map the counters are published in.
+func (e *ExpvarInstrumentation) Map() *expvar.Map {
+	return e.vars
+}
+
+// RequestFinished implements Instrumentation.
+func (e *ExpvarInstrumentation) RequestFinished(_ context.Context, op Operation, _ int, _ int, latency time.Duration, err error) {
+	e.vars.Add("requests."+string(op), 1)
+	e.vars.Add("latency_ms."+string(op), latency.Milliseconds())
+	if err != nil {
+		e.vars.Add("errors."+string(op), 1)
+	}
+}
+
+// RequestRetried implements Instrumentation.
+func (e *ExpvarInstrumentation) RequestRetried(_ context.Context, op Operation, _ int, _ time.Duration, _ error) {
+	e.vars.Add("retries."+string(op), 1)
+}
+
+// ChunkUploaded implements Instrumentation.
+func (e *ExpvarInstrumentation) ChunkUploaded(_ context.Context, _ uuid.UUID, _ int64, bytes int) {
+	e.vars.Add("chunks_uploaded", 1)
+	e.vars.Add("bytes_uploaded", int64(bytes))
+}
+
+// FindingsReturned implements Instrumentation.
+func (e *ExpvarInstrumentation) FindingsReturned(_ context.Context, _ Operation, findings int) {
+	e.vars.Add("findings", int64(findings))
+}
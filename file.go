@@ -2,9 +2,11 @@ package nightfall
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -29,6 +31,8 @@ type ScanFileRequest struct {
 	Content          io.Reader     `json:"-"`
 	ContentSizeBytes int64         `json:"-"`
 	Timeout          time.Duration `json:"-"`
+	// Progress, if set, is called with the progress of the upload.
+	Progress ProgressFunc `json:"-"`
 }
 
 // ScanFileResponse is the object returned by the Nightfall API when an (asynchronous) file scan request
@@ -49,12 +53,35 @@ type fileUploadRequest struct {
 	FileSizeBytes int64 `json:"fileSizeBytes"`
 }
 
+// UploadSession is a file upload session. It exposes the individual steps of the file upload and scan process
+// that ScanFile performs at once, so that a file may for instance be scanned with several policies, or uploaded and
+// scanned by different processes.
+type UploadSession struct {
+	// ID identifies the uploaded file.
+	ID uuid.UUID
+	// FileSizeBytes is the size of the file.
+	FileSizeBytes int64
+	// ChunkSize is the size of the chunks the file must be uploaded in; only the last chunk may be smaller.
+	ChunkSize int64
+	// MIMEType is the type of the file as detected by the server. It may be empty until the upload is finished.
+	MIMEType string
+
+	client *Client
+}
+
+var (
+	errInvalidChunk        = errors.New("chunk does not match the upload session's chunk size")
+	errContentSizeMismatch = errors.New("content size does not match ContentSizeBytes")
+)
+
 // ScanFile is a convenience method that abstracts the details of the multi-step file upload and scan process.
 // Calling this method for a given file is equivalent to (1) manually initializing a file upload session,
 // (2) uploading all chunks of the file, (3) completing the upload, and (4) triggering a scan of the file.
+// These steps may also be performed individually through InitUpload and UploadSession.
 //
 // The maximum allowed ContentSizeBytes is dependent on the terms of your current
-// Nightfall usage plan agreement; check the Nightfall dashboard for more details.
+// Nightfall usage plan agreement; check the Nightfall dashboard for more details. If ContentSizeBytes is zero or
+// less, the content is read in full to determine its size before it is uploaded; see OptionSpool.
 //
 // This method consumes the provided reader, but it does not close it; closing remains
 // the caller's responsibility.
@@ -67,22 +94,132 @@ func (c *Client) ScanFile(ctx context.Context, request *ScanFileRequest) (*ScanF
 	}
 	defer cancel()
 
-	fileUpload, err := c.initFileUpload(ctx, &fileUploadRequest{FileSizeBytes: request.ContentSizeBytes})
+	if request.ContentSizeBytes <= 0 {
+		content, size, cleanup, err := c.spool(ctx, request.Content)
+		defer cleanup()
+		if err != nil {
+			return nil, err
+		}
+		spooled := *request
+		spooled.Content, spooled.ContentSizeBytes = content, size
+		request = &spooled
+	}
+	progress := newProgressTracker(request.Progress, request.ContentSizeBytes, cancel)
+
+	if err := progress.phase(UploadPhaseInit, uuid.Nil, 0); err != nil {
+		return nil, err
+	}
+	session, err := c.InitUpload(ctx, request.ContentSizeBytes)
 	if err != nil {
+		return nil, progress.failure(err)
+	}
+	if err := progress.phase(UploadPhaseUpload, session.ID, 0); err != nil {
 		return nil, err
 	}
+	if err := c.doChunkedUpload(ctx, session, request.Content, progress); err != nil {
+		return nil, progress.failure(err)
+	}
+	return c.finishAndScan(ctx, session, request, progress)
+}
 
-	err = c.doChunkedUpload(ctx, fileUpload, request.Content)
-	if err != nil {
+// finishAndScan finishes an upload and scans the file, reporting progress.
+func (c *Client) finishAndScan(ctx context.Context, session *UploadSession, request *ScanFileRequest, progress *progressTracker) (*ScanFileResponse, error) {
+	if err := progress.phase(UploadPhaseFinish, session.ID, 0); err != nil {
+		return nil, err
+	}
+	if err := session.Finish(ctx); err != nil {
+		return nil, progress.failure(err)
+	}
+	if err := progress.phase(UploadPhaseScan, session.ID, 0); err != nil {
 		return nil, err
 	}
+	resp, err := session.Scan(ctx, request)
+	if err != nil {
+		return nil, progress.failure(err)
+	}
+	return resp, nil
+}
 
-	err = c.completeFileUpload(ctx, fileUpload.ID)
+// InitUpload initializes a session to upload a file of the given size.
+func (c *Client) InitUpload(ctx context.Context, fileSizeBytes int64) (*UploadSession, error) {
+	fileUpload, err := c.initFileUpload(ctx, &fileUploadRequest{FileSizeBytes: fileSizeBytes})
 	if err != nil {
-		return nil, err
+		return nil, &UploadError{Phase: UploadPhaseInit, Err: err}
 	}
+	c.logger.Info("initialized file upload", "upload_id", fileUpload.ID.String(), "size", fileUpload.FileSizeBytes,
+		"chunk_size", fileUpload.ChunkSize)
 
-	return c.scanUploadedFile(ctx, request, fileUpload.ID)
+	return &UploadSession{
+		ID:            fileUpload.ID,
+		FileSizeBytes: fileUpload.FileSizeBytes,
+		ChunkSize:     fileUpload.ChunkSize,
+		MIMEType:      fileUpload.MIMEType,
+		client:        c,
+	}, nil
+}
+
+// OpenUploadSession returns the session of an upload that was initialized earlier, possibly by another client.
+// Chunks cannot be uploaded unless chunkSize is positive.
+func (c *Client) OpenUploadSession(id uuid.UUID, fileSizeBytes, chunkSize int64) *UploadSession {
+	return &UploadSession{ID: id, FileSizeBytes: fileSizeBytes, ChunkSize: chunkSize, client: c}
+}
+
+// UploadChunk uploads the chunk of the file starting at offset, which must be a multiple of the chunk size. data
+// must be a whole chunk, unless it is the last chunk of the file. Chunks may be uploaded concurrently and in any
+// order.
+func (s *UploadSession) UploadChunk(ctx context.Context, offset int64, data []byte) error {
+	if s.ChunkSize <= 0 || offset < 0 || offset%s.ChunkSize != 0 || int64(len(data)) > s.ChunkSize ||
+		offset+int64(len(data)) > s.FileSizeBytes || (int64(len(data)) < s.ChunkSize && offset+int64(len(data)) != s.FileSizeBytes) {
+		return &UploadError{Phase: UploadPhaseUpload, FileID: s.ID, Offset: offset, Err: errInvalidChunk}
+	}
+	return s.uploadChunk(ctx, offset, data, nil)
+}
+
+// UploadAll uploads the whole file, reading it from content. Chunks are uploaded concurrently, as configured with
+// OptionFileUploadConcurrency or OptionAdaptiveFileUploadConcurrency.
+func (s *UploadSession) UploadAll(ctx context.Context, content io.Reader) error {
+	return s.client.doChunkedUpload(ctx, s, content, nil)
+}
+
+// Finish completes the upload. The file can be scanned once the upload is finished.
+func (s *UploadSession) Finish(ctx context.Context) error {
+	if err := s.client.completeFileUpload(ctx, s.ID); err != nil {
+		return &UploadError{Phase: UploadPhaseFinish, FileID: s.ID, Err: err}
+	}
+	s.client.logger.Debug("completed file upload", "upload_id", s.ID.String())
+	return nil
+}
+
+// Scan triggers a scan of the uploaded file with the policy of the request. The request's Content and
+// ContentSizeBytes are ignored. A file may be scanned several times, for instance with different policies.
+func (s *UploadSession) Scan(ctx context.Context, request *ScanFileRequest) (*ScanFileResponse, error) {
+	scanResponse, err := s.client.scanUploadedFile(ctx, request, s.ID)
+	if err != nil {
+		return nil, &UploadError{Phase: UploadPhaseScan, FileID: s.ID, Err: err}
+	}
+	s.client.logger.Info("started file scan", "upload_id", s.ID.String(), "scan_id", scanResponse.ID)
+	return scanResponse, nil
+}
+
+// uploadChunk uploads a chunk, reporting the outcome to observe if it is set.
+func (s *UploadSession) uploadChunk(ctx context.Context, offset int64, data []byte, observe func(*Response, error, time.Duration)) error {
+	c := s.client
+	reqParams := requestParams{
+		operation: OperationUploadChunk,
+		method:    http.MethodPatch,
+		url:       c.baseURL + "v3/upload/" + s.ID.String(),
+		body:      data,
+		headers:   c.chunkedUploadHeaders(offset),
+		// Re-sending the same bytes at the same offset overwrites the chunk
+		idempotent: true,
+		observe:    observe,
+	}
+	if err := c.do(ctx, reqParams, nil); err != nil {
+		return &UploadError{Phase: UploadPhaseUpload, FileID: s.ID, Offset: offset, Err: err}
+	}
+	c.logger.Debug("uploaded file chunk", "upload_id", s.ID.String(), "offset", offset, "bytes", len(data))
+	c.instrumentation.ChunkUploaded(ctx, s.ID, offset, len(data))
+	return nil
 }
 
 func (c *Client) initFileUpload(ctx context.Context, request *fileUploadRequest) (*fileUploadResponse, error) {
@@ -91,10 +228,11 @@ func (c *Client) initFileUpload(ctx context.Context, request *fileUploadRequest)
 		return nil, err
 	}
 	reqParams := requestParams{
-		method:  http.MethodPost,
-		url:     c.baseURL + "v3/upload",
-		body:    body,
-		headers: c.defaultHeaders(),
+		operation: OperationInitUpload,
+		method:    http.MethodPost,
+		url:       c.baseURL + "v3/upload",
+		body:      body,
+		headers:   c.defaultHeaders(),
 	}
 
 	uploadResponse := &fileUploadResponse{}
@@ -106,60 +244,180 @@ func (c *Client) initFileUpload(ctx context.Context, request *fileUploadRequest)
 	return uploadResponse, nil
 }
 
-func (c *Client) doChunkedUpload(ctx context.Context, fileUpload *fileUploadResponse, content io.Reader) error {
+func (c *Client) doChunkedUpload(ctx context.Context, fileUpload *UploadSession, content io.Reader, progress *progressTracker) error {
+	if fileUpload.ChunkSize <= 0 {
+		return &UploadError{Phase: UploadPhaseUpload, FileID: fileUpload.ID, Err: errInvalidChunk}
+	}
+	var sent int64
+	acked := func(offset int64, n int, attempts int) {
+		atomic.AddInt64(&sent, int64(n))
+		progress.chunk(int(offset/fileUpload.ChunkSize), n, attempts)
+	}
+
+	// Content that can be read at any offset is read by the upload goroutines in parallel
+	if readerAt, ok := contentReaderAt(content, fileUpload.FileSizeBytes); ok {
+		read := func(offset int64) ([]byte, error) {
+			return fileUpload.readChunkAt(readerAt, offset)
+		}
+		if err := c.uploadChunks(ctx, fileUpload, fileUpload.chunkOffsets(), read, acked, true); err != nil {
+			return err
+		}
+	} else {
+		read := func(offset int64) ([]byte, error) {
+			buf := make([]byte, fileUpload.chunkLen(offset))
+			bytesRead, err := io.ReadFull(content, buf)
+			if err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return buf[:bytesRead], nil
+		}
+		if err := c.uploadChunks(ctx, fileUpload, fileUpload.chunkOffsets(), read, acked, false); err != nil {
+			return err
+		}
+	}
+
+	// Content shorter than its declared size would otherwise be finished as a truncated file
+	if sent != fileUpload.FileSizeBytes {
+		return &UploadError{Phase: UploadPhaseUpload, FileID: fileUpload.ID, Offset: sent, Err: errContentSizeMismatch}
+	}
+	return nil
+}
+
+// chunkLen returns the size of the chunk at offset.
+func (s *UploadSession) chunkLen(offset int64) int64 {
+	if offset+s.ChunkSize > s.FileSizeBytes {
+		return s.FileSizeBytes - offset
+	}
+	return s.ChunkSize
+}
+
+// readChunkAt reads the whole chunk at offset.
+func (s *UploadSession) readChunkAt(r io.ReaderAt, offset int64) ([]byte, error) {
+	buf := make([]byte, s.chunkLen(offset))
+	n, err := r.ReadAt(buf, offset)
+	if n == len(buf) {
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		// The content is expected to be as large as its declared size
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+// contentReaderAt returns a reader of the size bytes following the current position of content, if content can be
+// read at any offset. Content such as a pipe may implement io.ReaderAt without supporting it, which is detected by
+// seeking.
+func contentReaderAt(content io.Reader, size int64) (io.ReaderAt, bool) {
+	readerAt, isReaderAt := content.(io.ReaderAt)
+	seeker, isSeeker := content.(io.Seeker)
+	if !isReaderAt || !isSeeker {
+		return nil, false
+	}
+	pos, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false
+	}
+	return io.NewSectionReader(readerAt, pos, size), true
+}
+
+// chunkOffsets returns the offsets of all chunks of the file.
+func (s *UploadSession) chunkOffsets() []int64 {
+	var offsets []int64
+	for offset := int64(0); offset < s.FileSizeBytes; offset += s.ChunkSize {
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+// uploadChunks concurrently uploads the chunks at the given offsets, in order. Chunks are read with read, which
+// returns io.EOF if the content ends before the offset. If parallel is set, each upload goroutine reads its own
+// chunk; otherwise chunks are read one after the other. acked, if set, is called after every uploaded chunk with
+// the number of attempts it took.
+func (c *Client) uploadChunks(ctx context.Context, fileUpload *UploadSession, offsets []int64,
+	read func(offset int64) ([]byte, error), acked func(offset int64, n int, attempts int), parallel bool) error {
 	errChan := make(chan error, 1)
 	wg := &sync.WaitGroup{}
-	concurrencyChan := make(chan struct{}, c.fileUploadConcurrency)
+	sem := c.uploadSemaphore()
 
 	uploadCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	var observe func(*Response, error, time.Duration)
+	if c.adaptiveUploads != nil {
+		observe = c.adaptiveUploads.observe
+	}
+
+	// If error channel is full already just discard this error, first error is most likely the most useful one anyways
+	fail := func(err error) {
+		select {
+		case errChan <- err:
+		default:
+		}
+		cancel()
+	}
+
+	var offset int64
 upload:
-	for offset := int64(0); offset < fileUpload.FileSizeBytes; offset += fileUpload.ChunkSize {
+	for _, offset = range offsets {
 		// Check if we are at max upload concurrency limit and block if we are
-		concurrencyChan <- struct{}{}
+		if err := sem.acquire(uploadCtx); err != nil {
+			break upload
+		}
 
 		// Check if there were any errors from uploading previous chunks, and break if there were
 		select {
 		case <-uploadCtx.Done():
+			sem.release()
 			break upload
 		default:
 		}
 
-		buf := make([]byte, fileUpload.ChunkSize)
-		bytesRead, err := content.Read(buf)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
-		if int64(bytesRead) < fileUpload.ChunkSize {
-			buf = buf[:bytesRead]
+		var buf []byte
+		if !parallel {
+			var err error
+			buf, err = read(offset)
+			if err == io.EOF {
+				sem.release()
+				break
+			} else if err != nil {
+				sem.release()
+				fail(&UploadError{Phase: UploadPhaseUpload, FileID: fileUpload.ID, Offset: offset, Err: err})
+				break
+			}
 		}
 
 		wg.Add(1)
 		go func(o int64, data []byte) {
 			defer func() {
 				wg.Done()
-				<-concurrencyChan
+				sem.release()
 			}()
 
-			reqParams := requestParams{
-				method:  http.MethodPatch,
-				url:     c.baseURL + "v3/upload/" + fileUpload.ID.String(),
-				body:    data,
-				headers: c.chunkedUploadHeaders(o),
+			if parallel {
+				var err error
+				if data, err = read(o); err != nil {
+					fail(&UploadError{Phase: UploadPhaseUpload, FileID: fileUpload.ID, Offset: o, Err: err})
+					return
+				}
 			}
-			err = c.do(uploadCtx, reqParams, nil)
-			if err != nil {
-				// If error channel is full already just discard this error, first error is most likely the most useful one anyways
-				select {
-				case errChan <- err:
-				default:
+			attempts := 0
+			observeChunk := func(resp *Response, err error, latency time.Duration) {
+				attempts++
+				if observe != nil {
+					observe(resp, err, latency)
 				}
-				cancel()
+			}
+			if err := fileUpload.uploadChunk(uploadCtx, o, data, observeChunk); err != nil {
+				fail(err)
 				return
 			}
+			if acked != nil {
+				acked(o, len(data), attempts)
+			}
 		}(offset, buf)
 	}
 
@@ -169,16 +427,20 @@ upload:
 	if err := <-errChan; err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return &UploadError{Phase: UploadPhaseUpload, FileID: fileUpload.ID, Offset: offset, Err: err}
+	}
 
 	return nil
 }
 
 func (c *Client) completeFileUpload(ctx context.Context, fileUUID uuid.UUID) error {
 	reqParams := requestParams{
-		method:  http.MethodPost,
-		url:     c.baseURL + "v3/upload/" + fileUUID.String() + "/finish",
-		body:    nil,
-		headers: c.defaultHeaders(),
+		operation: OperationFinishUpload,
+		method:    http.MethodPost,
+		url:       c.baseURL + "v3/upload/" + fileUUID.String() + "/finish",
+		body:      nil,
+		headers:   c.defaultHeaders(),
 	}
 	return c.do(ctx, reqParams, nil)
 }
@@ -189,10 +451,11 @@ func (c *Client) scanUploadedFile(ctx context.Context, request *ScanFileRequest,
 		return nil, err
 	}
 	reqParams := requestParams{
-		method:  http.MethodPost,
-		url:     c.baseURL + "v3/upload/" + fileUUID.String() + "/scan",
-		body:    body,
-		headers: c.defaultHeaders(),
+		operation: OperationScanFile,
+		method:    http.MethodPost,
+		url:       c.baseURL + "v3/upload/" + fileUUID.String() + "/scan",
+		body:      body,
+		headers:   c.defaultHeaders(),
 	}
 
 	scanResponse := &ScanFileResponse{}
@@ -3,10 +3,16 @@ package nightfall
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/google/uuid"
@@ -155,11 +161,10 @@ func TestScanFile(t *testing.T) {
 			s := httptest.NewServer(mux)
 			defer s.Close()
 
-			client, err := NewClient(OptionAPIKey("some key"), OptionFileUploadConcurrency(2))
+			client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionFileUploadConcurrency(2))
 			if err != nil {
 				t.Fatal("Error initializing client")
 			}
-			client.baseURL = s.URL + "/"
 
 			_, err = client.ScanFile(context.Background(), &ScanFileRequest{
 				Content:          strings.NewReader("4242 4242 4242 4242"),
@@ -178,3 +183,215 @@ func TestScanFile(t *testing.T) {
 		})
 	}
 }
+
+func TestUploadSession(t *testing.T) {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	reqUUID := uuid.MustParse(uuidStr)
+	var mu sync.Mutex
+	var requests []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-Upload-Offset"))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/v3/upload":
+			b, _ := json.Marshal(fileUploadResponse{ID: reqUUID, FileSizeBytes: 12, ChunkSize: 5, MIMEType: "text/plain"})
+			_, _ = w.Write(b)
+		case "/v3/upload/" + uuidStr + "/scan":
+			req := &ScanFileRequest{}
+			_ = json.NewDecoder(r.Body).Decode(req)
+			b, _ := json.Marshal(ScanFileResponse{ID: *req.PolicyUUID})
+			_, _ = w.Write(b)
+		}
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	ctx := context.Background()
+
+	session, err := client.InitUpload(ctx, 12)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if session.ID != reqUUID || session.FileSizeBytes != 12 || session.ChunkSize != 5 || session.MIMEType != "text/plain" {
+		t.Errorf("Unexpected upload session: %+v", session)
+	}
+
+	for _, chunk := range []struct {
+		offset int64
+		data   string
+	}{{offset: 3, data: "abcde"}, {offset: 5, data: "abc"}, {offset: 10, data: "abc"}, {offset: 15, data: ""}} {
+		err := session.UploadChunk(ctx, chunk.offset, []byte(chunk.data))
+		if !errors.Is(err, errInvalidChunk) {
+			t.Errorf("Expected invalid chunk error uploading %q at offset %d, got %v", chunk.data, chunk.offset, err)
+		}
+	}
+
+	// A session without a chunk size cannot upload anything
+	for _, chunkSize := range []int64{0, -1} {
+		invalid := client.OpenUploadSession(reqUUID, 12, chunkSize)
+		if err := invalid.UploadAll(ctx, strings.NewReader("abcdefghijkl")); !errors.Is(err, errInvalidChunk) {
+			t.Errorf("Expected invalid chunk error with chunk size %d, got %v", chunkSize, err)
+		}
+	}
+
+	// Upload the chunks from another session in reverse order
+	other := client.OpenUploadSession(reqUUID, 12, 5)
+	for _, chunk := range []struct {
+		offset int64
+		data   string
+	}{{offset: 10, data: "kl"}, {offset: 5, data: "fghij"}, {offset: 0, data: "abcde"}} {
+		if err := other.UploadChunk(ctx, chunk.offset, []byte(chunk.data)); err != nil {
+			t.Fatalf("Got unexpected error uploading chunk at offset %d: %v", chunk.offset, err)
+		}
+	}
+	if err := session.Finish(ctx); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	for _, policy := range []string{"policy 1", "policy 2"} {
+		policy := policy
+		resp, err := session.Scan(ctx, &ScanFileRequest{PolicyUUID: &policy})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if resp.ID != policy {
+			t.Errorf("Expected scan with %s, got %s", policy, resp.ID)
+		}
+	}
+
+	expected := []string{
+		"POST /v3/upload ",
+		"PATCH /v3/upload/" + uuidStr + " 10",
+		"PATCH /v3/upload/" + uuidStr + " 5",
+		"PATCH /v3/upload/" + uuidStr + " 0",
+		"POST /v3/upload/" + uuidStr + "/finish ",
+		"POST /v3/upload/" + uuidStr + "/scan ",
+		"POST /v3/upload/" + uuidStr + "/scan ",
+	}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected requests:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(requests, "\n"))
+	}
+}
+
+func TestScanFileChunkReads(t *testing.T) {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	content := "0123456789abcdefghijk"
+
+	tests := []struct {
+		name     string
+		content  io.Reader
+		size     int64
+		wantErr  error
+		expBytes string
+	}{
+		{name: "short reads", content: iotest.HalfReader(strings.NewReader(content)), size: 21, expBytes: content},
+		{name: "reader at", content: strings.NewReader(content), size: 21, expBytes: content},
+		{name: "truncated content", content: iotest.HalfReader(strings.NewReader(content[:12])), size: 21, wantErr: errContentSizeMismatch},
+		{name: "truncated reader at", content: strings.NewReader(content[:12]), size: 21, wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			uploaded := map[string]string{}
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v3/upload":
+					b, _ := json.Marshal(fileUploadResponse{ID: uuid.MustParse(uuidStr), FileSizeBytes: tt.size, ChunkSize: 5})
+					_, _ = w.Write(b)
+				case "/v3/upload/" + uuidStr:
+					b, _ := io.ReadAll(r.Body)
+					mu.Lock()
+					uploaded[r.Header.Get("X-Upload-Offset")] = string(b)
+					mu.Unlock()
+				case "/v3/upload/" + uuidStr + "/scan":
+					_, _ = w.Write([]byte(`{"id":"scan"}`))
+				}
+			}))
+			defer s.Close()
+
+			client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+			if err != nil {
+				t.Fatal("Error initializing client")
+			}
+			_, err = client.ScanFile(context.Background(), &ScanFileRequest{Content: tt.content, ContentSizeBytes: tt.size})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			for offset := 0; offset < len(tt.expBytes); offset += 5 {
+				end := offset + 5
+				if end > len(tt.expBytes) {
+					end = len(tt.expBytes)
+				}
+				if got := uploaded[strconv.Itoa(offset)]; got != tt.expBytes[offset:end] {
+					t.Errorf("Expected chunk %q at offset %d, got %q", tt.expBytes[offset:end], offset, got)
+				}
+			}
+		})
+	}
+}
+
+func TestScanFileContentPosition(t *testing.T) {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	content := "skip0123456789abcdefghijk"
+
+	pipe := func() io.Reader {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		go func() {
+			_, _ = io.WriteString(w, content[4:])
+			w.Close()
+		}()
+		return r
+	}
+	seeked := func() io.Reader {
+		r := strings.NewReader(content)
+		_, _ = r.Seek(4, io.SeekStart)
+		return r
+	}
+
+	for name, newContent := range map[string]func() io.Reader{"pipe": pipe, "seeked": seeked} {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			uploaded := map[string]string{}
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v3/upload":
+					b, _ := json.Marshal(fileUploadResponse{ID: uuid.MustParse(uuidStr), FileSizeBytes: 21, ChunkSize: 5})
+					_, _ = w.Write(b)
+				case "/v3/upload/" + uuidStr:
+					b, _ := io.ReadAll(r.Body)
+					mu.Lock()
+					uploaded[r.Header.Get("X-Upload-Offset")] = string(b)
+					mu.Unlock()
+				case "/v3/upload/" + uuidStr + "/scan":
+					_, _ = w.Write([]byte(`{"id":"scan"}`))
+				}
+			}))
+			defer s.Close()
+
+			client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+			if err != nil {
+				t.Fatal("Error initializing client")
+			}
+			if _, err := client.ScanFile(context.Background(), &ScanFileRequest{Content: newContent(), ContentSizeBytes: 21}); err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			got := ""
+			for offset := 0; offset < 21; offset += 5 {
+				got += uploaded[strconv.Itoa(offset)]
+			}
+			if got != content[4:] {
+				t.Errorf("Expected uploaded content %q, got %q", content[4:], got)
+			}
+		})
+	}
+}
@@ -0,0 +1,502 @@
+package nightfall
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FindingsHeader is the header HTTPActionAnnotate sets to the number of findings in a request or response body.
+const FindingsHeader = "X-Nightfall-Findings"
+
+// DefaultMaxHTTPBodyBytes is the default size of the largest body scanned by the middleware returned by
+// NewHTTPMiddleware.
+const DefaultMaxHTTPBodyBytes = 1 << 20
+
+// DefaultMaxAsyncHTTPScans is the default number of bodies the middleware returned by NewHTTPMiddleware scans in
+// the background at once.
+const DefaultMaxAsyncHTTPScans = 64
+
+var (
+	errNothingToScan     = errors.New("at least one of ScanRequests and ScanResponses must be set")
+	errInvalidSampleRate = errors.New("sampleRate must be in range [0,1]")
+	errTooManyAsyncScans = errors.New("too many bodies are being scanned in the background")
+)
+
+// HTTPAction is what the middleware returned by NewHTTPMiddleware does with a body that has findings.
+type HTTPAction int
+
+const (
+	// HTTPActionAnnotate passes the body on unchanged, and sets FindingsHeader on the request or response.
+	HTTPActionAnnotate HTTPAction = iota
+	// HTTPActionBlock replaces the response with an error response.
+	HTTPActionBlock
+	// HTTPActionRedact replaces the body with its redacted version. The policy must configure a redaction.
+	HTTPActionRedact
+)
+
+// HTTPMiddlewareConfig configures the middleware returned by NewHTTPMiddleware.
+type HTTPMiddlewareConfig struct {
+	// Policy and PolicyUUIDs select the policy bodies are scanned with, as in a ScanTextRequest.
+	Policy      *Config
+	PolicyUUIDs []string
+	// ScanRequests and ScanResponses select which bodies are scanned. At least one must be set.
+	ScanRequests  bool
+	ScanResponses bool
+	// Action is what is done with a body that has findings.
+	Action HTTPAction
+	// BlockStatusCode is the status code of the response sent instead of a blocked request or response. Defaults
+	// to http.StatusForbidden.
+	BlockStatusCode int
+	// ContentTypes are the media types of the bodies that are scanned. An entry may end with "/*" to match all
+	// subtypes. Defaults to JSON, form and plain text bodies. JSON bodies are scanned and redacted string by string,
+	// form bodies value by value and other bodies as a whole.
+	ContentTypes []string
+	// MaxBodyBytes is the size of the largest body that is scanned; larger bodies are passed on unscanned.
+	// Defaults to DefaultMaxHTTPBodyBytes.
+	MaxBodyBytes int64
+	// SampleRate is the fraction of requests that are scanned, in range [0,1]. Zero means every request is scanned.
+	SampleRate float64
+	// Async scans bodies in the background without affecting requests or responses, so that scanning adds no
+	// latency. Findings are only reported through OnFindings.
+	Async bool
+	// MaxAsyncScans is the number of bodies scanned in the background at once when Async is set. Bodies arriving
+	// while that many are being scanned are not scanned, and OnError is called for them. Defaults to
+	// DefaultMaxAsyncHTTPScans.
+	MaxAsyncScans int
+	// OnFindings, if set, is called with the findings of every scanned body that has any. response is set when
+	// the findings are in the response body.
+	OnFindings func(r *http.Request, response bool, findings []*Finding)
+	// OnError, if set, is called when a body cannot be scanned. Such bodies are passed on unchanged, unless
+	// FailClosed is set.
+	OnError func(r *http.Request, err error)
+	// FailClosed blocks requests and responses whose body cannot be scanned. Bodies of a scanned content type that
+	// are larger than MaxBodyBytes or compressed are rejected with http.StatusRequestEntityTooLarge and
+	// http.StatusUnsupportedMediaType, unless Async is set.
+	FailClosed bool
+}
+
+type httpScanner struct {
+	client *Client
+	config HTTPMiddlewareConfig
+	// audits holds a token for every body being scanned in the background
+	audits chan struct{}
+}
+
+// NewHTTPMiddleware returns net/http middleware that scans request and response bodies with the client, and
+// blocks, redacts or annotates those that have findings.
+func NewHTTPMiddleware(client *Client, config HTTPMiddlewareConfig) (func(http.Handler) http.Handler, error) {
+	if !config.ScanRequests && !config.ScanResponses {
+		return nil, errNothingToScan
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, errInvalidSampleRate
+	}
+	if config.Action == HTTPActionRedact && len(config.PolicyUUIDs) == 0 && !redacts(config.Policy) {
+		return nil, errMissingRedactionConfig
+	}
+	if config.BlockStatusCode == 0 {
+		config.BlockStatusCode = http.StatusForbidden
+	}
+	if len(config.ContentTypes) == 0 {
+		config.ContentTypes = []string{"application/json", "application/x-www-form-urlencoded", "text/plain"}
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = DefaultMaxHTTPBodyBytes
+	}
+	if config.MaxAsyncScans <= 0 {
+		config.MaxAsyncScans = DefaultMaxAsyncHTTPScans
+	}
+
+	s := &httpScanner{client: client, config: config, audits: make(chan struct{}, config.MaxAsyncScans)}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.config.SampleRate > 0 && rand.Float64() >= s.config.SampleRate {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s.serveHTTP(w, r, next)
+		})
+	}, nil
+}
+
+func (s *httpScanner) serveHTTP(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if s.config.ScanRequests && !s.handleRequest(w, r) {
+		return
+	}
+	if !s.config.ScanResponses {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	rb := &responseBuffer{w: w, limit: s.config.MaxBodyBytes, status: http.StatusOK, tee: s.config.Async,
+		failClosed: s.config.FailClosed, scannable: s.scannable}
+	next.ServeHTTP(rb, r)
+	if rb.rejected != 0 {
+		reject(w, rb.rejected)
+		return
+	}
+	if !rb.buffering {
+		if s.config.Async && rb.scanning {
+			s.startAudit(r, rb.header.Get("Content-Type"), rb.buf.Bytes(), true)
+		}
+		return
+	}
+	s.handleResponse(w, r, rb)
+}
+
+// handleRequest scans the request body, and reports whether the request should be passed on.
+func (s *httpScanner) handleRequest(w http.ResponseWriter, r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if r.Body == nil || r.Body == http.NoBody || !s.scannable(contentType) {
+		return true
+	}
+	failClosed := s.config.FailClosed && !s.config.Async
+	if !identityEncoded(r.Header) || r.ContentLength > s.config.MaxBodyBytes {
+		if failClosed {
+			status := http.StatusRequestEntityTooLarge
+			if !identityEncoded(r.Header) {
+				status = http.StatusUnsupportedMediaType
+			}
+			reject(w, status)
+			return false
+		}
+		return true
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, s.config.MaxBodyBytes+1))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return false
+	}
+	if int64(len(body)) > s.config.MaxBodyBytes {
+		if failClosed {
+			reject(w, http.StatusRequestEntityTooLarge)
+			return false
+		}
+		// Too large to be scanned; pass on the body including the part that was already read
+		r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+		return true
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if s.config.Async {
+		s.startAudit(r, contentType, body, false)
+		return true
+	}
+
+	findings, redacted, err := s.scanBody(r.Context(), contentType, body)
+	if err != nil {
+		return s.handleError(w, r, err)
+	}
+	if len(findings) == 0 {
+		return true
+	}
+	if s.config.OnFindings != nil {
+		s.config.OnFindings(r, false, findings)
+	}
+	switch s.config.Action {
+	case HTTPActionBlock:
+		http.Error(w, http.StatusText(s.config.BlockStatusCode), s.config.BlockStatusCode)
+		return false
+	case HTTPActionRedact:
+		r.Body = io.NopCloser(bytes.NewReader(redacted))
+		r.ContentLength = int64(len(redacted))
+		r.Header.Set("Content-Length", strconv.Itoa(len(redacted)))
+	default:
+		r.Header.Set(FindingsHeader, strconv.Itoa(len(findings)))
+	}
+	return true
+}
+
+// handleResponse scans a buffered response, and writes it or the response replacing it.
+func (s *httpScanner) handleResponse(w http.ResponseWriter, r *http.Request, rb *responseBuffer) {
+	body := rb.buf.Bytes()
+	findings, redacted, err := s.scanBody(r.Context(), w.Header().Get("Content-Type"), body)
+	if err != nil {
+		if !s.handleError(w, r, err) {
+			return
+		}
+		findings = nil
+	}
+	if len(findings) > 0 {
+		if s.config.OnFindings != nil {
+			s.config.OnFindings(r, true, findings)
+		}
+		switch s.config.Action {
+		case HTTPActionBlock:
+			w.Header().Del("Content-Length")
+			http.Error(w, http.StatusText(s.config.BlockStatusCode), s.config.BlockStatusCode)
+			return
+		case HTTPActionRedact:
+			body = redacted
+		default:
+			w.Header().Set(FindingsHeader, strconv.Itoa(len(findings)))
+		}
+	}
+	if w.Header().Get("Content-Length") != "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(rb.status)
+	_, _ = w.Write(body)
+}
+
+// reject replaces a request or response whose body cannot be scanned with an error response.
+func reject(w http.ResponseWriter, status int) {
+	w.Header().Del("Content-Length")
+	w.Header().Del("Content-Encoding")
+	http.Error(w, http.StatusText(status), status)
+}
+
+// handleError reports a failed scan, and reports whether the request or response should be passed on.
+func (s *httpScanner) handleError(w http.ResponseWriter, r *http.Request, err error) bool {
+	if s.config.OnError != nil {
+		s.config.OnError(r, err)
+	}
+	if s.config.FailClosed {
+		w.Header().Del("Content-Length")
+		http.Error(w, http.StatusText(s.config.BlockStatusCode), s.config.BlockStatusCode)
+		return false
+	}
+	return true
+}
+
+// startAudit audits a body in the background, unless too many bodies are already being audited.
+func (s *httpScanner) startAudit(r *http.Request, contentType string, body []byte, response bool) {
+	select {
+	case s.audits <- struct{}{}:
+	default:
+		if s.config.OnError != nil {
+			s.config.OnError(r, errTooManyAsyncScans)
+		}
+		return
+	}
+	go func() {
+		defer func() { <-s.audits }()
+		s.audit(r, contentType, body, response)
+	}()
+}
+
+// audit scans a body and reports its findings.
+func (s *httpScanner) audit(r *http.Request, contentType string, body []byte, response bool) {
+	findings, _, err := s.scanBody(context.Background(), contentType, body)
+	if err != nil {
+		if s.config.OnError != nil {
+			s.config.OnError(r, err)
+		}
+		return
+	}
+	if len(findings) > 0 && s.config.OnFindings != nil {
+		s.config.OnFindings(r, response, findings)
+	}
+}
+
+// scannable reports whether bodies with the content type are scanned.
+func (s *httpScanner) scannable(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range s.config.ContentTypes {
+		t = strings.ToLower(t)
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// identityEncoded reports whether a body with the header is not compressed or otherwise encoded, and so can be
+// scanned.
+func identityEncoded(header http.Header) bool {
+	encoding := header.Get("Content-Encoding")
+	return encoding == "" || strings.EqualFold(encoding, "identity")
+}
+
+// scanBody scans a body, returning its findings and, if the action is to redact, the redacted body.
+func (s *httpScanner) scanBody(ctx context.Context, contentType string, body []byte) ([]*Finding, []byte, error) {
+	items, rebuild, err := bodyItems(contentType, body)
+	if err != nil || len(items) == 0 {
+		return nil, nil, err
+	}
+	resp, err := s.client.ScanText(ctx, &ScanTextRequest{Payload: items, Policy: s.config.Policy, PolicyUUIDs: s.config.PolicyUUIDs})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var findings []*Finding
+	redacted := make([]string, len(items))
+	copy(redacted, items)
+	for i, itemFindings := range resp.Findings {
+		findings = append(findings, itemFindings...)
+		if len(itemFindings) > 0 && i < len(resp.RedactedPayload) && resp.RedactedPayload[i] != "" {
+			redacted[i] = resp.RedactedPayload[i]
+		}
+	}
+	if len(findings) == 0 || s.config.Action != HTTPActionRedact {
+		return findings, nil, nil
+	}
+	out, err := rebuild(redacted)
+	return findings, out, err
+}
+
+// bodyItems splits a body into the payload items it is scanned as, and returns a function that rebuilds the body
+// from redacted items.
+func bodyItems(contentType string, body []byte) ([]string, func([]string) ([]byte, error), error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		v, err := decodeJSON(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		var items []string
+		rewriteJSON(v, func(s string) string {
+			items = append(items, s)
+			return s
+		})
+		return items, func(redacted []string) ([]byte, error) {
+			i := 0
+			v = rewriteJSON(v, func(string) string {
+				i++
+				return redacted[i-1]
+			})
+			return marshalJSON(v)
+		}, nil
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var items []string
+		for _, k := range keys {
+			items = append(items, values[k]...)
+		}
+		return items, func(redacted []string) ([]byte, error) {
+			i := 0
+			for _, k := range keys {
+				for j := range values[k] {
+					values[k][j] = redacted[i]
+					i++
+				}
+			}
+			return []byte(values.Encode()), nil
+		}, nil
+	default:
+		return []string{string(body)}, func(redacted []string) ([]byte, error) {
+			return []byte(redacted[0]), nil
+		}, nil
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// responseBuffer is an http.ResponseWriter that holds back a response so that it can be scanned. Responses that
+// are not scanned, because of their content type, encoding or size, are passed through, unless failClosed is set
+// and the content type is scanned, in which case they are rejected. When tee is set, responses are always passed
+// through, and a copy is kept for scanning.
+type responseBuffer struct {
+	w          http.ResponseWriter
+	header     http.Header
+	limit      int64
+	status     int
+	tee        bool
+	failClosed bool
+	scannable  func(contentType string) bool
+
+	buf         bytes.Buffer
+	wroteHeader bool
+	// buffering is set while the response is being held back, and scanning while a copy is kept
+	buffering bool
+	scanning  bool
+	// rejected is the status of the error response replacing a response that cannot be scanned
+	rejected int
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	return rb.w.Header()
+}
+
+func (rb *responseBuffer) WriteHeader(status int) {
+	if rb.wroteHeader {
+		return
+	}
+	rb.wroteHeader = true
+	rb.status = status
+	rb.header = rb.w.Header().Clone()
+	scannable := rb.scannable(rb.header.Get("Content-Type"))
+	rb.scanning = scannable && identityEncoded(rb.header)
+	rb.buffering = rb.scanning && !rb.tee
+	if scannable && !rb.scanning && rb.failClosed && !rb.tee {
+		// Discard the response, which is replaced by an error response
+		rb.rejected = http.StatusUnsupportedMediaType
+		rb.buffering = true
+	}
+	if !rb.buffering {
+		rb.w.WriteHeader(status)
+	}
+}
+
+func (rb *responseBuffer) Write(p []byte) (int, error) {
+	if !rb.wroteHeader {
+		if rb.w.Header().Get("Content-Type") == "" {
+			rb.w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		rb.WriteHeader(http.StatusOK)
+	}
+	if rb.scanning && int64(rb.buf.Len()+len(p)) > rb.limit {
+		// Too large to be scanned; pass on what was held back and the rest of the response, or discard it all
+		rb.scanning = false
+		if rb.buffering && rb.failClosed {
+			rb.rejected = http.StatusRequestEntityTooLarge
+		} else if rb.buffering {
+			rb.buffering = false
+			rb.w.WriteHeader(rb.status)
+			if _, err := rb.w.Write(rb.buf.Bytes()); err != nil {
+				return 0, err
+			}
+		}
+		rb.buf.Reset()
+	}
+	if rb.scanning {
+		rb.buf.Write(p)
+	}
+	if rb.buffering {
+		return len(p), nil
+	}
+	return rb.w.Write(p)
+}
+
+// Flush sends the response written so far, unless it is being held back to be scanned.
+func (rb *responseBuffer) Flush() {
+	if !rb.wroteHeader {
+		rb.WriteHeader(http.StatusOK)
+	}
+	if rb.buffering {
+		return
+	}
+	if f, ok := rb.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use by http.ResponseController.
+func (rb *responseBuffer) Unwrap() http.ResponseWriter {
+	return rb.w
+}
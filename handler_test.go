@@ -0,0 +1,470 @@
+package nightfall
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	tests := []struct {
+		name            string
+		config          HTTPMiddlewareConfig
+		contentType     string
+		body            string
+		respContentType string
+		respBody        string
+		expCalls        int32
+		expStatus       int
+		expUpstreamBody string
+		expHeader       string
+		expRespBody     string
+		expRespHeader   string
+	}{
+		{
+			name:            "annotate request",
+			config:          HTTPMiddlewareConfig{ScanRequests: true},
+			contentType:     "application/json",
+			body:            `{"b":"ok","a":"my secret"}`,
+			expCalls:        1,
+			expStatus:       http.StatusOK,
+			expUpstreamBody: `{"b":"ok","a":"my secret"}`,
+			expHeader:       "1",
+			expRespBody:     "done",
+		},
+		{
+			name:        "block request",
+			config:      HTTPMiddlewareConfig{ScanRequests: true, Action: HTTPActionBlock, BlockStatusCode: http.StatusUnprocessableEntity},
+			contentType: "text/plain; charset=utf-8",
+			body:        "my secret",
+			expCalls:    1,
+			expStatus:   http.StatusUnprocessableEntity,
+			expRespBody: "Unprocessable Entity\n",
+		},
+		{
+			name:            "redact JSON request",
+			config:          HTTPMiddlewareConfig{ScanRequests: true, Action: HTTPActionRedact, Policy: testRedactionPolicy},
+			contentType:     "application/json",
+			body:            `{"b":"<ok> & more","a":["my secret",1.50]}`,
+			expCalls:        1,
+			expStatus:       http.StatusOK,
+			expUpstreamBody: `{"a":["[my secret]",1.50],"b":"<ok> & more"}`,
+			expRespBody:     "done",
+		},
+		{
+			name:            "redact form request",
+			config:          HTTPMiddlewareConfig{ScanRequests: true, Action: HTTPActionRedact, Policy: testRedactionPolicy},
+			contentType:     "application/x-www-form-urlencoded",
+			body:            "q=secret+stuff&page=1",
+			expCalls:        1,
+			expStatus:       http.StatusOK,
+			expUpstreamBody: "page=1&q=%5Bsecret+stuff%5D",
+			expRespBody:     "done",
+		},
+		{
+			name:            "request without findings",
+			config:          HTTPMiddlewareConfig{ScanRequests: true, Action: HTTPActionBlock},
+			contentType:     "text/plain",
+			body:            "nothing here",
+			expCalls:        1,
+			expStatus:       http.StatusOK,
+			expUpstreamBody: "nothing here",
+			expRespBody:     "done",
+		},
+		{
+			name:            "content type not scanned",
+			config:          HTTPMiddlewareConfig{ScanRequests: true, Action: HTTPActionBlock},
+			contentType:     "application/octet-stream",
+			body:            "my secret",
+			expStatus:       http.StatusOK,
+			expUpstreamBody: "my secret",
+			expRespBody:     "done",
+		},
+		{
+			name:            "request too large",
+			config:          HTTPMiddlewareConfig{ScanRequests: true, Action: HTTPActionBlock, MaxBodyBytes: 4},
+			contentType:     "text/plain",
+			body:            "my secret",
+			expStatus:       http.StatusOK,
+			expUpstreamBody: "my secret",
+			expRespBody:     "done",
+		},
+		{
+			name:        "request too large fail closed",
+			config:      HTTPMiddlewareConfig{ScanRequests: true, Action: HTTPActionBlock, MaxBodyBytes: 4, FailClosed: true},
+			contentType: "text/plain",
+			body:        "my secret",
+			expStatus:   http.StatusRequestEntityTooLarge,
+			expRespBody: "Request Entity Too Large\n",
+		},
+		{
+			name:        "JSON request with trailing data",
+			config:      HTTPMiddlewareConfig{ScanRequests: true, Action: HTTPActionBlock, FailClosed: true},
+			contentType: "application/json",
+			body:        `{"a":"ok"} "my secret"`,
+			expStatus:   http.StatusForbidden,
+			expRespBody: "Forbidden\n",
+		},
+		{
+			name:            "redact response",
+			config:          HTTPMiddlewareConfig{ScanResponses: true, Action: HTTPActionRedact, Policy: testRedactionPolicy},
+			respContentType: "text/plain",
+			respBody:        "your secret",
+			expCalls:        1,
+			expStatus:       http.StatusOK,
+			expRespBody:     "[your secret]",
+		},
+		{
+			name:            "annotate response",
+			config:          HTTPMiddlewareConfig{ScanResponses: true, ContentTypes: []string{"text/*"}},
+			respContentType: "text/html",
+			respBody:        "your secret",
+			expCalls:        1,
+			expStatus:       http.StatusOK,
+			expRespBody:     "your secret",
+			expRespHeader:   "1",
+		},
+		{
+			name:            "block response",
+			config:          HTTPMiddlewareConfig{ScanResponses: true, Action: HTTPActionBlock},
+			respContentType: "text/plain",
+			respBody:        "your secret",
+			expCalls:        1,
+			expStatus:       http.StatusForbidden,
+			expRespBody:     "Forbidden\n",
+		},
+		{
+			name:            "response too large",
+			config:          HTTPMiddlewareConfig{ScanResponses: true, Action: HTTPActionBlock, MaxBodyBytes: 4},
+			respContentType: "text/plain",
+			respBody:        "your secret",
+			expStatus:       http.StatusOK,
+			expRespBody:     "your secret",
+		},
+		{
+			name:            "response too large fail closed",
+			config:          HTTPMiddlewareConfig{ScanResponses: true, Action: HTTPActionBlock, MaxBodyBytes: 4, FailClosed: true},
+			respContentType: "text/plain",
+			respBody:        "your secret",
+			expStatus:       http.StatusRequestEntityTooLarge,
+			expRespBody:     "Request Entity Too Large\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&calls, 0)
+			middleware, err := NewHTTPMiddleware(client, tt.config)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+
+			var upstreamBody, header string
+			h := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				upstreamBody = string(b)
+				header = r.Header.Get(FindingsHeader)
+				if tt.respBody == "" {
+					_, _ = io.WriteString(w, "done")
+					return
+				}
+				w.Header().Set("Content-Type", tt.respContentType)
+				_, _ = io.WriteString(w, tt.respBody[:4])
+				_, _ = io.WriteString(w, tt.respBody[4:])
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if calls != tt.expCalls {
+				t.Errorf("Expected %d scans, got %d", tt.expCalls, calls)
+			}
+			if rec.Code != tt.expStatus {
+				t.Errorf("Expected status %d, got %d", tt.expStatus, rec.Code)
+			}
+			if upstreamBody != tt.expUpstreamBody {
+				t.Errorf("Expected upstream body %q, got %q", tt.expUpstreamBody, upstreamBody)
+			}
+			if header != tt.expHeader {
+				t.Errorf("Expected request findings header %q, got %q", tt.expHeader, header)
+			}
+			if rec.Body.String() != tt.expRespBody {
+				t.Errorf("Expected response body %q, got %q", tt.expRespBody, rec.Body.String())
+			}
+			if got := rec.Header().Get(FindingsHeader); got != tt.expRespHeader {
+				t.Errorf("Expected response findings header %q, got %q", tt.expRespHeader, got)
+			}
+		})
+	}
+}
+
+func TestHTTPMiddlewareAsync(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	found := make(chan bool, 2)
+	middleware, err := NewHTTPMiddleware(client, HTTPMiddlewareConfig{
+		ScanRequests:  true,
+		ScanResponses: true,
+		Action:        HTTPActionBlock,
+		Async:         true,
+		OnFindings: func(r *http.Request, response bool, findings []*Finding) {
+			found <- response
+		},
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	h := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.Copy(w, r.Body)
+	}))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("my secret"))
+	req.Header.Set("Content-Type", "text/plain")
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "my secret" {
+		t.Errorf("Expected response to pass through, got %d %q", rec.Code, rec.Body.String())
+	}
+	seen := map[bool]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case response := <-found:
+			seen[response] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected findings to be reported")
+		}
+	}
+	if !seen[true] || !seen[false] {
+		t.Errorf("Expected findings in both request and response, got %v", seen)
+	}
+}
+
+func TestHTTPMiddlewareAsyncLimit(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	scan := echoScanHandler(&calls, 0)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		scan(w, r)
+	}))
+	defer s.Close()
+	defer close(release)
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	dropped := make(chan error, 1)
+	middleware, err := NewHTTPMiddleware(client, HTTPMiddlewareConfig{
+		ScanRequests:  true,
+		Async:         true,
+		MaxAsyncScans: 1,
+		OnError:       func(r *http.Request, err error) { dropped <- err },
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	h := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// The first body is held up being scanned, so the second is not scanned
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("my secret"))
+		req.Header.Set("Content-Type", "text/plain")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	select {
+	case err := <-dropped:
+		if !errors.Is(err, errTooManyAsyncScans) {
+			t.Errorf("Expected too many scans error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected dropped scan to be reported")
+	}
+}
+
+func TestHTTPMiddlewareContentEncoding(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	for _, tt := range []struct {
+		scanRequests bool
+		failClosed   bool
+		expStatus    int
+		expBody      string
+	}{
+		{scanRequests: true, expStatus: http.StatusOK, expBody: "my secret"},
+		{scanRequests: true, failClosed: true, expStatus: http.StatusUnsupportedMediaType, expBody: "Unsupported Media Type\n"},
+		{failClosed: true, expStatus: http.StatusUnsupportedMediaType, expBody: "Unsupported Media Type\n"},
+	} {
+		middleware, err := NewHTTPMiddleware(client, HTTPMiddlewareConfig{
+			ScanRequests:  tt.scanRequests,
+			ScanResponses: true,
+			Action:        HTTPActionBlock,
+			FailClosed:    tt.failClosed,
+		})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		h := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", r.Header.Get("Content-Encoding"))
+			_, _ = io.Copy(w, r.Body)
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("my secret"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if calls != 0 {
+			t.Errorf("Expected encoded bodies not to be scanned, got %d scans", calls)
+		}
+		if rec.Code != tt.expStatus || rec.Body.String() != tt.expBody {
+			t.Errorf("Expected %d %q with failClosed %v, got %d %q", tt.expStatus, tt.expBody, tt.failClosed, rec.Code, rec.Body.String())
+		}
+		if tt.failClosed && rec.Header().Get("Content-Encoding") != "" {
+			t.Error("Expected the error response not to be encoded")
+		}
+	}
+}
+
+func TestHTTPMiddlewareFlush(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	middleware, err := NewHTTPMiddleware(client, HTTPMiddlewareConfig{ScanResponses: true, Action: HTTPActionBlock})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		contentType string
+		expFlushed  bool
+		expStatus   int
+	}{
+		{contentType: "application/octet-stream", expFlushed: true, expStatus: http.StatusOK},
+		{contentType: "text/plain", expFlushed: false, expStatus: http.StatusForbidden},
+	} {
+		var unwrapped http.ResponseWriter
+		h := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); ok {
+				unwrapped = u.Unwrap()
+			}
+			w.Header().Set("Content-Type", tt.contentType)
+			_, _ = io.WriteString(w, "my secret")
+			w.(http.Flusher).Flush()
+		}))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if unwrapped != rec {
+			t.Errorf("Expected the response writer to unwrap to the recorder")
+		}
+		if rec.Flushed != tt.expFlushed {
+			t.Errorf("Expected flushed %v for %s, got %v", tt.expFlushed, tt.contentType, rec.Flushed)
+		}
+		if rec.Code != tt.expStatus {
+			t.Errorf("Expected status %d for %s, got %d", tt.expStatus, tt.contentType, rec.Code)
+		}
+	}
+}
+
+func TestHTTPMiddlewareScanError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	for _, failClosed := range []bool{false, true} {
+		var scanErr error
+		middleware, err := NewHTTPMiddleware(client, HTTPMiddlewareConfig{
+			ScanRequests: true,
+			FailClosed:   failClosed,
+			OnError:      func(r *http.Request, err error) { scanErr = err },
+		})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		h := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("my secret"))
+		req.Header.Set("Content-Type", "text/plain")
+		h.ServeHTTP(rec, req)
+
+		if scanErr == nil {
+			t.Error("Expected scan error to be reported")
+		}
+		expStatus := http.StatusOK
+		if failClosed {
+			expStatus = http.StatusForbidden
+		}
+		if rec.Code != expStatus {
+			t.Errorf("Expected status %d with failClosed %v, got %d", expStatus, failClosed, rec.Code)
+		}
+	}
+}
+
+func TestNewHTTPMiddlewareValidation(t *testing.T) {
+	client, err := NewClient(OptionAPIKey("some key"))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	tests := []struct {
+		config HTTPMiddlewareConfig
+		err    error
+	}{
+		{config: HTTPMiddlewareConfig{}, err: errNothingToScan},
+		{config: HTTPMiddlewareConfig{ScanRequests: true, SampleRate: 2}, err: errInvalidSampleRate},
+		{config: HTTPMiddlewareConfig{ScanRequests: true, Action: HTTPActionRedact}, err: errMissingRedactionConfig},
+		{config: HTTPMiddlewareConfig{ScanRequests: true, Action: HTTPActionRedact, PolicyUUIDs: []string{"uuid"}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewHTTPMiddleware(client, tt.config); !errors.Is(err, tt.err) {
+			t.Errorf("Expected error %v, got %v", tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package nightfall
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Instrumentation receives callbacks about the requests the client makes, so that metrics and traces can be
+// published to any monitoring system. Implementations must be safe for concurrent use, and should return quickly
+// since callbacks are invoked synchronously on the request path. Embed NopInstrumentation to implement only
+// the callbacks of interest.
+type Instrumentation interface {
+	// RequestStarted is called before every attempt of a request. The returned context is used for the attempt,
+	// which allows tracing implementations to start a span and propagate it to RequestFinished and Middleware.
+	RequestStarted(ctx context.Context, op Operation, attempt int) context.Context
+	// RequestFinished is called after every attempt of a request, with the context returned by RequestStarted.
+	// statusCode is 0 if no response was received.
+	RequestFinished(ctx context.Context, op Operation, attempt int, statusCode int, latency time.Duration, err error)
+	// RequestRetried is called when a failed attempt is about to be retried after waiting for backoff.
+	RequestRetried(ctx context.Context, op Operation, attempt int, backoff time.Duration, err error)
+	// ChunkUploaded is called after a chunk of a file was successfully uploaded.
+	ChunkUploaded(ctx context.Context, uploadID uuid.UUID, offset int64, bytes int)
+	// FindingsReturned is called with the total number of findings returned by a successful text scan.
+	FindingsReturned(ctx context.Context, op Operation, findings int)
+}
+
+// OptionInstrumentation sets the instrumentation notified about requests made by the Nightfall client
+func OptionInstrumentation(instrumentation Instrumentation) func(*Client) error {
+	return func(c *Client) error {
+		if instrumentation == nil {
+			instrumentation = NopInstrumentation{}
+		}
+		c.instrumentation = instrumentation
+		return nil
+	}
+}
+
+// NopInstrumentation is an Instrumentation that does nothing.
+type NopInstrumentation struct{}
+
+// RequestStarted implements Instrumentation.
+func (NopInstrumentation) RequestStarted(ctx context.Context, _ Operation, _ int) context.Context {
+	return ctx
+}
+
+// RequestFinished implements Instrumentation.
+func (NopInstrumentation) RequestFinished(context.Context, Operation, int, int, time.Duration, error) {
+}
+
+// RequestRetried implements Instrumentation.
+func (NopInstrumentation) RequestRetried(context.Context, Operation, int, time.Duration, error) {}
+
+// ChunkUploaded implements Instrumentation.
+func (NopInstrumentation) ChunkUploaded(context.Context, uuid.UUID, int64, int) {}
+
+// FindingsReturned implements Instrumentation.
+func (NopInstrumentation) FindingsReturned(context.Context, Operation, int) {}
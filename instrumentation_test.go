@@ -0,0 +1,179 @@
+package nightfall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ctxKey struct{}
+
+type testInstrumentation struct {
+	mu       sync.Mutex
+	started  int
+	finished int
+	retried  int
+	chunks   int
+	bytes    int
+	findings int
+	spanless int
+}
+
+func (i *testInstrumentation) RequestStarted(ctx context.Context, op Operation, attempt int) context.Context {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.started++
+	return context.WithValue(ctx, ctxKey{}, "span")
+}
+
+func (i *testInstrumentation) RequestFinished(ctx context.Context, op Operation, attempt int, statusCode int, latency time.Duration, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.finished++
+	if ctx.Value(ctxKey{}) != "span" {
+		i.spanless++
+	}
+}
+
+func (i *testInstrumentation) RequestRetried(ctx context.Context, op Operation, attempt int, backoff time.Duration, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.retried++
+}
+
+func (i *testInstrumentation) ChunkUploaded(ctx context.Context, uploadID uuid.UUID, offset int64, bytes int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.chunks++
+	i.bytes += bytes
+}
+
+func (i *testInstrumentation) FindingsReturned(ctx context.Context, op Operation, findings int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.findings += findings
+}
+
+func newInstrumentationTestServer() *httptest.Server {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	var scanCalls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/upload", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := json.Marshal(fileUploadResponse{ID: uuid.MustParse(uuidStr), FileSizeBytes: 15, ChunkSize: 5})
+		_, _ = w.Write(b)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr+"/finish", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr+"/scan", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"some scan"}`))
+	})
+	mux.HandleFunc("/v3/scan", func(w http.ResponseWriter, r *http.Request) {
+		scanCalls++
+		if scanCalls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"findings":[[{"finding":"a"},{"finding":"b"}],[{"finding":"c"}]]}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func runInstrumentedScans(t *testing.T, instrumentation Instrumentation) {
+	s := newInstrumentationTestServer()
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionRetryPolicy(testRetryPolicy), OptionInstrumentation(instrumentation))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	_, err = client.ScanFile(context.Background(), &ScanFileRequest{
+		Content:          strings.NewReader("4242 4242 4242 4242"),
+		ContentSizeBytes: 15,
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	_, err = client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"a b", "c"}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+}
+
+func TestInstrumentation(t *testing.T) {
+	i := &testInstrumentation{}
+	runInstrumentedScans(t, i)
+
+	if i.started != 8 || i.finished != 8 {
+		t.Errorf("Expected 8 started and finished requests, got %d and %d", i.started, i.finished)
+	}
+	if i.spanless != 0 {
+		t.Error("RequestFinished did not receive the context returned by RequestStarted")
+	}
+	if i.retried != 1 {
+		t.Errorf("Expected 1 retry, got %d", i.retried)
+	}
+	if i.chunks != 3 || i.bytes != 15 {
+		t.Errorf("Expected 3 chunks of 15 bytes, got %d chunks of %d bytes", i.chunks, i.bytes)
+	}
+	if i.findings != 3 {
+		t.Errorf("Expected 3 findings, got %d", i.findings)
+	}
+}
+
+// expvarTestRuns makes the names of the maps published by TestExpvarInstrumentation unique, since expvar maps
+// cannot be unpublished and the test may run several times.
+var expvarTestRuns int32
+
+func TestExpvarInstrumentation(t *testing.T) {
+	name := "nightfall_test_" + strconv.Itoa(int(atomic.AddInt32(&expvarTestRuns, 1)))
+	e, err := NewExpvarInstrumentation(name)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	runInstrumentedScans(t, e)
+
+	expCounts := map[string]int64{
+		"requests.scan_text":    2,
+		"errors.scan_text":      1,
+		"retries.scan_text":     1,
+		"requests.upload_chunk": 3,
+		"chunks_uploaded":       3,
+		"bytes_uploaded":        15,
+		"findings":              3,
+	}
+	for key, exp := range expCounts {
+		v, ok := e.Map().Get(key).(*expvar.Int)
+		if !ok {
+			t.Errorf("Counter %q was not published", key)
+			continue
+		}
+		if v.Value() != exp {
+			t.Errorf("Expected counter %q to be %d, got %d", key, exp, v.Value())
+		}
+	}
+
+	if shared, err := NewExpvarInstrumentation(name); err != nil || shared.Map() != e.Map() {
+		t.Errorf("Expected instrumentation with the same name to share counters, got error %v", err)
+	}
+
+	expvar.NewString(name + "_string")
+	if _, err := NewExpvarInstrumentation(name + "_string"); !errors.Is(err, errExpvarNameTaken) {
+		t.Errorf("Expected name taken error, got %v", err)
+	}
+}
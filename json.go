@@ -0,0 +1,318 @@
+package nightfall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errTrailingJSONData = errors.New("unexpected data after JSON value")
+
+// ScanJSONRequest is the request struct to scan a JSON document with ScanJSON.
+type ScanJSONRequest struct {
+	// Document is the JSON document to scan.
+	Document json.RawMessage
+	// Policy and PolicyUUIDs select the policy the document is scanned with, as in a ScanTextRequest.
+	Policy      *Config
+	PolicyUUIDs []string
+	// IncludeKeys scans the keys of objects as well as string values.
+	IncludeKeys bool
+	// Redact returns a copy of the document in which strings with findings are replaced by their redacted
+	// version. The policy must configure a redaction.
+	Redact bool
+}
+
+// ScanJSONResponse is the response object returned by ScanJSON.
+type ScanJSONResponse struct {
+	// Findings are the findings in the document, in the order they appear in it.
+	Findings []*JSONFinding
+	// RedactedDocument is the redacted copy of the document, if it was requested and the document has findings.
+	// Only the strings with findings are replaced; everything else is copied verbatim. A key that is redacted to
+	// the key of another member of its object is given a numeric suffix, so that no member is lost.
+	RedactedDocument json.RawMessage
+}
+
+// JSONFinding is a finding in a JSON document.
+type JSONFinding struct {
+	*Finding
+	// Path is the JSON Pointer (RFC 6901) to the string the finding is in. The locations of the finding are
+	// relative to the decoded string.
+	Path string
+	// Key is set if the finding is in the key of the object member Path points to, rather than in its value.
+	Key bool
+}
+
+// jsonString is a string in a JSON document.
+type jsonString struct {
+	path  string
+	value string
+	key   bool
+	// start and end are the offsets of the quoted string in the document, and object numbers the object a key
+	// belongs to
+	start, end int
+	object     int
+}
+
+// ScanJSON scans the strings in a JSON document with a single ScanText call, each string being a payload item,
+// and reports where in the document the findings are.
+func (c *Client) ScanJSON(ctx context.Context, request *ScanJSONRequest) (*ScanJSONResponse, error) {
+	if request.Redact && len(request.PolicyUUIDs) == 0 && !redacts(request.Policy) {
+		return nil, errMissingRedactionConfig
+	}
+	if _, err := decodeJSON(request.Document); err != nil {
+		return nil, err
+	}
+	strs, err := jsonStrings(request.Document)
+	if err != nil {
+		return nil, err
+	}
+
+	// Empty strings cannot contain findings, so they are not sent
+	var payload []string
+	var indexes []int
+	for i, s := range strs {
+		if s.value != "" && (!s.key || request.IncludeKeys) {
+			payload = append(payload, s.value)
+			indexes = append(indexes, i)
+		}
+	}
+	result := &ScanJSONResponse{}
+	if len(payload) == 0 {
+		return result, nil
+	}
+
+	resp, err := c.ScanText(ctx, &ScanTextRequest{Payload: payload, Policy: request.Policy, PolicyUUIDs: request.PolicyUUIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	redacted := make([]string, len(strs))
+	for i, s := range strs {
+		redacted[i] = s.value
+	}
+	for j, i := range indexes {
+		if j >= len(resp.Findings) || len(resp.Findings[j]) == 0 {
+			continue
+		}
+		for _, f := range resp.Findings[j] {
+			result.Findings = append(result.Findings, &JSONFinding{Finding: f, Path: strs[i].path, Key: strs[i].key})
+		}
+		if j < len(resp.RedactedPayload) && resp.RedactedPayload[j] != "" {
+			redacted[i] = resp.RedactedPayload[j]
+		}
+	}
+
+	if request.Redact && len(result.Findings) > 0 {
+		result.RedactedDocument, err = spliceJSON(request.Document, strs, redacted)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers verbatim. Data following the value is an error, since it
+// would otherwise go unscanned.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errTrailingJSONData
+	}
+	return v, nil
+}
+
+// jsonStrings returns the strings in a JSON document, including object keys, in the order they appear in it. The
+// document must be valid.
+func jsonStrings(doc []byte) ([]jsonString, error) {
+	s := &jsonScanner{data: doc}
+	if err := s.value(""); err != nil {
+		return nil, err
+	}
+	return s.strs, nil
+}
+
+// jsonScanner locates the strings in a valid JSON document.
+type jsonScanner struct {
+	data    []byte
+	pos     int
+	objects int
+	strs    []jsonString
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.data) && strings.IndexByte(" \t\r\n", s.data[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+// value scans the value at the current position, whose JSON Pointer is path.
+func (s *jsonScanner) value(path string) error {
+	s.skipSpace()
+	switch s.data[s.pos] {
+	case '{':
+		object := s.objects
+		s.objects++
+		s.pos++
+		s.skipSpace()
+		for s.data[s.pos] != '}' {
+			key, err := s.string(path, true, object)
+			if err != nil {
+				return err
+			}
+			s.skipSpace()
+			// Skip the colon
+			s.pos++
+			if err := s.value(path + "/" + escapeJSONPointer(key)); err != nil {
+				return err
+			}
+			s.skipSpace()
+			if s.data[s.pos] == ',' {
+				s.pos++
+				s.skipSpace()
+			}
+		}
+		s.pos++
+	case '[':
+		s.pos++
+		s.skipSpace()
+		for i := 0; s.data[s.pos] != ']'; i++ {
+			if err := s.value(path + "/" + strconv.Itoa(i)); err != nil {
+				return err
+			}
+			s.skipSpace()
+			if s.data[s.pos] == ',' {
+				s.pos++
+				s.skipSpace()
+			}
+		}
+		s.pos++
+	case '"':
+		_, err := s.string(path, false, 0)
+		return err
+	default:
+		// Numbers and literals end at the next delimiter
+		for s.pos < len(s.data) && strings.IndexByte(",]} \t\r\n", s.data[s.pos]) < 0 {
+			s.pos++
+		}
+	}
+	return nil
+}
+
+// string scans the string at the current position, which is an object key if key is set, and returns its value.
+func (s *jsonScanner) string(path string, key bool, object int) (string, error) {
+	s.skipSpace()
+	start := s.pos
+	for s.pos++; s.data[s.pos] != '"'; s.pos++ {
+		if s.data[s.pos] == '\\' {
+			s.pos++
+		}
+	}
+	s.pos++
+
+	var value string
+	if err := json.Unmarshal(s.data[start:s.pos], &value); err != nil {
+		return "", err
+	}
+	if key {
+		path += "/" + escapeJSONPointer(value)
+	}
+	s.strs = append(s.strs, jsonString{path: path, value: value, key: key, start: start, end: s.pos, object: object})
+	return value, nil
+}
+
+// spliceJSON returns a copy of a JSON document in which the strings that were redacted are replaced.
+func spliceJSON(doc []byte, strs []jsonString, redacted []string) ([]byte, error) {
+	// Keys that are not redacted keep their name, and redacted keys are made unique among them
+	names := map[int]map[string]bool{}
+	for i, s := range strs {
+		if s.key && redacted[i] == s.value {
+			if names[s.object] == nil {
+				names[s.object] = map[string]bool{}
+			}
+			names[s.object][s.value] = true
+		}
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for i, s := range strs {
+		if redacted[i] == s.value {
+			continue
+		}
+		replacement := redacted[i]
+		if s.key {
+			if names[s.object] == nil {
+				names[s.object] = map[string]bool{}
+			}
+			replacement = uniqueKey(names[s.object], replacement)
+			names[s.object][replacement] = true
+		}
+		quoted, err := marshalJSON(replacement)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(doc[last:s.start])
+		out.Write(quoted)
+		last = s.end
+	}
+	out.Write(doc[last:])
+	return out.Bytes(), nil
+}
+
+// rewriteJSON replaces every string value in a decoded JSON value with the result of rewrite. Strings are visited
+// in a deterministic order, object members being ordered by key.
+func rewriteJSON(v interface{}, rewrite func(string) string) interface{} {
+	switch t := v.(type) {
+	case string:
+		return rewrite(t)
+	case []interface{}:
+		for i := range t {
+			t[i] = rewriteJSON(t[i], rewrite)
+		}
+	case map[string]interface{}:
+		names := make([]string, 0, len(t))
+		for k := range t {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			t[k] = rewriteJSON(t[k], rewrite)
+		}
+	}
+	return v
+}
+
+// uniqueKey returns name, suffixed with a number if it is already one of names.
+func uniqueKey(names map[string]bool, name string) string {
+	unique := name
+	for n := 2; names[unique]; n++ {
+		unique = name + "_" + strconv.Itoa(n)
+	}
+	return unique
+}
+
+// marshalJSON encodes v like json.Marshal, but without escaping the HTML characters &, < and >.
+func marshalJSON(v interface{}) ([]byte, error) {
+	b, err := encodeBodyAsJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b, []byte("\n")), nil
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// escapeJSONPointer escapes a reference token of a JSON Pointer.
+func escapeJSONPointer(token string) string {
+	return jsonPointerEscaper.Replace(token)
+}
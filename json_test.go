@@ -0,0 +1,163 @@
+package nightfall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScanJSON(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	document := `{"user": {"name": "alice", "notes": ["", "my secret"]}, "a/secret~key": 1.10, "ok": true}`
+	tests := []struct {
+		name        string
+		includeKeys bool
+		redact      bool
+		expPaths    []string
+		expRedacted string
+	}{
+		{
+			name:     "values",
+			expPaths: []string{"/user/notes/1"},
+		},
+		{
+			name:        "keys",
+			includeKeys: true,
+			expPaths:    []string{"/user/notes/1", "/a~1secret~0key"},
+		},
+		{
+			name:        "redacted",
+			includeKeys: true,
+			redact:      true,
+			expPaths:    []string{"/user/notes/1", "/a~1secret~0key"},
+			expRedacted: `{"user": {"name": "alice", "notes": ["", "[my secret]"]}, "[a/secret~key]": 1.10, "ok": true}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.ScanJSON(context.Background(), &ScanJSONRequest{
+				Document:    []byte(document),
+				Policy:      testRedactionPolicy,
+				IncludeKeys: tt.includeKeys,
+				Redact:      tt.redact,
+			})
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+
+			if len(resp.Findings) != len(tt.expPaths) {
+				t.Fatalf("Expected %d findings, got %d", len(tt.expPaths), len(resp.Findings))
+			}
+			for i, f := range resp.Findings {
+				if f.Path != tt.expPaths[i] {
+					t.Errorf("Expected finding %d at %s, got %s", i, tt.expPaths[i], f.Path)
+				}
+				if f.Key != (f.Path == "/a~1secret~0key") {
+					t.Errorf("Unexpected key flag for finding at %s", f.Path)
+				}
+			}
+			if string(resp.RedactedDocument) != tt.expRedacted {
+				t.Errorf("Expected redacted document %s, got %s", tt.expRedacted, resp.RedactedDocument)
+			}
+		})
+	}
+}
+
+func TestScanJSONRedactedKeys(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &ScanTextRequest{}
+		_ = json.NewDecoder(r.Body).Decode(req)
+		resp := &ScanTextResponse{Findings: make([][]*Finding, len(req.Payload)), RedactedPayload: make([]string, len(req.Payload))}
+		for i, item := range req.Payload {
+			if strings.HasPrefix(item, "secret") {
+				resp.Findings[i] = []*Finding{{Finding: item, RedactedFinding: "<redacted>"}}
+				resp.RedactedPayload[i] = "<redacted>"
+			}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	// Both keys are redacted to the same string, and the first to the key of another member
+	resp, err := client.ScanJSON(context.Background(), &ScanJSONRequest{
+		Document:    []byte(`{"secret 1": "a", "secret 2": "b", "<redacted>": "d"}`),
+		Policy:      testRedactionPolicy,
+		IncludeKeys: true,
+		Redact:      true,
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expRedacted := `{"<redacted>_2": "a", "<redacted>_3": "b", "<redacted>": "d"}`
+	if string(resp.RedactedDocument) != expRedacted {
+		t.Errorf("Expected redacted document %s, got %s", expRedacted, resp.RedactedDocument)
+	}
+}
+
+func TestScanJSONVerbatim(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	// Escaped strings are found, and both members with the same key are scanned
+	resp, err := client.ScanJSON(context.Background(), &ScanJSONRequest{
+		Document: []byte(`[ "my \"secret\"" , {"k": "ok", "k": "secret\u0021"}, 1e3 ]`),
+		Policy:   testRedactionPolicy,
+		Redact:   true,
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expRedacted := `[ "[my \"secret\"]" , {"k": "ok", "k": "[secret!]"}, 1e3 ]`
+	if string(resp.RedactedDocument) != expRedacted {
+		t.Errorf("Expected redacted document %s, got %s", expRedacted, resp.RedactedDocument)
+	}
+	if len(resp.Findings) != 2 || resp.Findings[0].Path != "/0" || resp.Findings[1].Path != "/1/k" {
+		t.Errorf("Unexpected findings: %v", resp.Findings)
+	}
+}
+
+func TestScanJSONErrors(t *testing.T) {
+	client, err := NewClient(OptionAPIKey("some key"))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	if _, err := client.ScanJSON(context.Background(), &ScanJSONRequest{Document: []byte(`{"a":`)}); err == nil {
+		t.Error("Expected error scanning invalid document")
+	}
+	_, err = client.ScanJSON(context.Background(), &ScanJSONRequest{Document: []byte(`{"a": "ok"} "my secret"`)})
+	if !errors.Is(err, errTrailingJSONData) {
+		t.Errorf("Expected trailing data error, got %v", err)
+	}
+	_, err = client.ScanJSON(context.Background(), &ScanJSONRequest{Document: []byte(`{}`), Policy: &Config{}, Redact: true})
+	if !errors.Is(err, errMissingRedactionConfig) {
+		t.Errorf("Expected missing redaction config error, got %v", err)
+	}
+	resp, err := client.ScanJSON(context.Background(), &ScanJSONRequest{Document: []byte(`[1, null, ""]`)})
+	if err != nil || len(resp.Findings) != 0 {
+		t.Errorf("Expected document without strings not to be scanned, got %v, %v", resp, err)
+	}
+}
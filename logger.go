@@ -0,0 +1,31 @@
+package nightfall
+
+// Logger is the structured logger used by the client to report requests, retries and file uploads. Arguments
+// alternate between string keys and values. A *slog.Logger satisfies this interface, as can any other structured
+// logger through a small adapter.
+//
+// The client never logs the API key, request or response bodies, or any other scanned content.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// OptionLogger sets the logger used by the Nightfall client. By default, the client does not log anything.
+func OptionLogger(logger Logger) func(*Client) error {
+	return func(c *Client) error {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		c.logger = logger
+		return nil
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,121 @@
+package nightfall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type logEntry struct {
+	level string
+	msg   string
+	args  []interface{}
+}
+
+type testLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) log(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, args: args})
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("debug", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("info", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("warn", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("error", msg, args) }
+
+func (l *testLogger) count(msg string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var n int
+	for _, e := range l.entries {
+		if e.msg == msg {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLogger(t *testing.T) {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	var scanCalls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/upload", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := json.Marshal(fileUploadResponse{ID: uuid.MustParse(uuidStr), FileSizeBytes: 15, ChunkSize: 5})
+		_, _ = w.Write(b)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr+"/finish", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr+"/scan", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := json.Marshal(ScanFileResponse{ID: "some scan", Message: "scan initiated"})
+		_, _ = w.Write(b)
+	})
+	mux.HandleFunc("/v3/scan", func(w http.ResponseWriter, r *http.Request) {
+		scanCalls++
+		if scanCalls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"findings":[[]],"redactedPayload":[""]}`))
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	logger := &testLogger{}
+	client, err := NewClient(OptionAPIKey("super secret key"), OptionBaseURL(s.URL), OptionRetryPolicy(testRetryPolicy), OptionLogger(logger))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	_, err = client.ScanFile(context.Background(), &ScanFileRequest{
+		Content:          strings.NewReader("4242 4242 4242 4242"),
+		ContentSizeBytes: 15,
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	_, err = client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"my ssn is 555-55-5555"}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	expCounts := map[string]int{
+		"initialized file upload":     1,
+		"uploaded file chunk":         3,
+		"completed file upload":       1,
+		"started file scan":           1,
+		"retrying nightfall request":  1,
+		"nightfall request failed":    1,
+		"nightfall request succeeded": 7,
+	}
+	for msg, exp := range expCounts {
+		if got := logger.count(msg); got != exp {
+			t.Errorf("Expected %d %q entries, got %d", exp, msg, got)
+		}
+	}
+
+	for _, e := range logger.entries {
+		line := fmt.Sprint(e.msg, e.args)
+		if strings.Contains(line, "super secret key") || strings.Contains(line, "4242") || strings.Contains(line, "555-55-5555") {
+			t.Errorf("Log entry leaked sensitive data: %s", line)
+		}
+		if len(e.args)%2 != 0 {
+			t.Errorf("Log entry has odd number of arguments: %s", line)
+		}
+	}
+}
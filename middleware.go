@@ -0,0 +1,69 @@
+package nightfall
+
+import (
+	"context"
+	"net/http"
+)
+
+// Operation identifies the logical Nightfall API call a request belongs to.
+type Operation string
+
+const (
+	OperationInitUpload   Operation = "init_upload"
+	OperationUploadChunk  Operation = "upload_chunk"
+	OperationFinishUpload Operation = "finish_upload"
+	OperationScanFile     Operation = "scan_file"
+	OperationScanText     Operation = "scan_text"
+)
+
+// Request is a single attempt of a call to the Nightfall API, as seen by a Middleware. Middleware may modify the
+// request, for example to add headers, before passing it on.
+type Request struct {
+	Operation Operation
+	// Attempt is 1 for the initial request and increases with every retry.
+	Attempt int
+	Method  string
+	URL     string
+	Header  http.Header
+	Body    []byte
+}
+
+// Response is the outcome of a single attempt of a call to the Nightfall API, as seen by a Middleware.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	// Result is the decoded response body, such as a *ScanTextResponse. It is nil for operations that do not
+	// return a body and for unsuccessful responses.
+	Result interface{}
+}
+
+// RoundTrip performs a single attempt of a call to the Nightfall API. A non-nil Response may accompany an error
+// when the server responded with an unsuccessful status code.
+type RoundTrip func(ctx context.Context, req *Request) (*Response, error)
+
+// Middleware wraps a RoundTrip to add behavior around every attempt the client makes, including retries.
+type Middleware func(next RoundTrip) RoundTrip
+
+// OptionMiddleware adds middleware around every request the Nightfall client makes. Middleware run in the order
+// provided, so the first middleware sees the request first and the response last. This option may be given
+// more than once; later middleware are nested inside earlier ones.
+func OptionMiddleware(middleware ...Middleware) func(*Client) error {
+	return func(c *Client) error {
+		c.middleware = append(c.middleware, middleware...)
+		return nil
+	}
+}
+
+func (c *Client) chain(rt RoundTrip) RoundTrip {
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		rt = c.middleware[i](rt)
+	}
+	return rt
+}
+
+func (r *Response) httpResponse() *http.Response {
+	if r == nil {
+		return nil
+	}
+	return &http.Response{StatusCode: r.StatusCode, Header: r.Header}
+}
@@ -0,0 +1,132 @@
+package nightfall
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMiddleware(t *testing.T) {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/upload", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := json.Marshal(fileUploadResponse{ID: uuid.MustParse(uuidStr), FileSizeBytes: 15, ChunkSize: 5})
+		_, _ = w.Write(b)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr+"/finish", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v3/upload/"+uuidStr+"/scan", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Tenant") != "some tenant" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := json.Marshal(ScanFileResponse{ID: uuidStr, Message: "scan initiated"})
+		_, _ = w.Write(b)
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	var mu sync.Mutex
+	var calls []string
+	var results []interface{}
+	record := func(name string) Middleware {
+		return func(next RoundTrip) RoundTrip {
+			return func(ctx context.Context, req *Request) (*Response, error) {
+				mu.Lock()
+				calls = append(calls, name+":"+string(req.Operation))
+				mu.Unlock()
+				return next(ctx, req)
+			}
+		}
+	}
+	tenant := func(next RoundTrip) RoundTrip {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			req.Header.Set("X-Tenant", "some tenant")
+			resp, err := next(ctx, req)
+			if resp != nil && resp.Result != nil {
+				mu.Lock()
+				results = append(results, resp.Result)
+				mu.Unlock()
+			}
+			return resp, err
+		}
+	}
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionMiddleware(record("outer"), tenant), OptionMiddleware(record("inner")))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	resp, err := client.ScanFile(context.Background(), &ScanFileRequest{
+		Content:          strings.NewReader("4242 4242 4242 4242"),
+		ContentSizeBytes: 15,
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	expCalls := []string{
+		"outer:init_upload", "inner:init_upload",
+		"outer:upload_chunk", "inner:upload_chunk",
+		"outer:upload_chunk", "inner:upload_chunk",
+		"outer:upload_chunk", "inner:upload_chunk",
+		"outer:finish_upload", "inner:finish_upload",
+		"outer:scan_file", "inner:scan_file",
+	}
+	if !reflect.DeepEqual(calls, expCalls) {
+		t.Errorf("Expected calls %v, got %v", expCalls, calls)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 decoded results, got %d", len(results))
+	}
+	if results[1] != resp {
+		t.Error("Middleware did not see the decoded scan response")
+	}
+}
+
+func TestMiddlewareFaultInjection(t *testing.T) {
+	var callCount int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		_, _ = w.Write([]byte(`{"findings":[[]],"redactedPayload":[""]}`))
+	}))
+	defer s.Close()
+
+	var attempts []int
+	faulty := func(next RoundTrip) RoundTrip {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			attempts = append(attempts, req.Attempt)
+			if req.Attempt == 1 {
+				return &Response{StatusCode: http.StatusServiceUnavailable}, &Error{StatusCode: http.StatusServiceUnavailable}
+			}
+			return next(ctx, req)
+		}
+	}
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionRetryPolicy(testRetryPolicy), OptionMiddleware(faulty))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	_, err = client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"hello"}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(attempts, []int{1, 2}) {
+		t.Errorf("Expected attempts [1 2], got %v", attempts)
+	}
+	if callCount != 1 {
+		t.Errorf("Expected 1 call to reach the server, got %d", callCount)
+	}
+}
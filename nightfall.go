@@ -9,10 +9,13 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime/debug"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
 const (
@@ -26,9 +29,29 @@ const (
 type Client struct {
 	baseURL               string
 	apiKey                string
+	credentials           CredentialProvider
 	httpClient            *http.Client
 	fileUploadConcurrency int
 	retryCount            int
+	userAgent             string
+	timeout               time.Duration
+	maxScanTextItems      int
+	maxScanTextBytes      int
+	scanTextConcurrency   int
+	scanTextWindowBytes   int
+	scanTextWindowOverlap int
+	retryPolicy           RetryPolicy
+	middleware            []Middleware
+	logger                Logger
+	instrumentation       Instrumentation
+	requestLimiter        RateLimiter
+	textScanLimiter       RateLimiter
+	uploadLimiter         RateLimiter
+	adaptiveUploads       *adaptiveLimiter
+	breaker               *circuitBreaker
+	spoolDir              string
+	spoolMemoryBytes      int64
+	spoolMaxBytes         int64
 }
 
 // ClientOption defines an option for a Client
@@ -37,7 +60,11 @@ type ClientOption func(*Client) error
 var (
 	errMissingAPIKey                = errors.New("missing api key")
 	errInvalidFileUploadConcurrency = errors.New("fileUploadConcurrency must be in range [1,100]")
-	errRetryable429                 = errors.New("429 retryable error")
+	errMissingRetryPolicy           = errors.New("retry policy must not be nil")
+	errInvalidBaseURL               = errors.New("base url must be an absolute http or https url")
+	errInvalidRetryCount            = errors.New("retryCount must not be negative")
+	errInvalidUserAgent             = errors.New("user agent must not contain control characters")
+	errInvalidTimeout               = errors.New("timeout must not be negative")
 
 	userAgent = loadUserAgent()
 )
@@ -50,6 +77,16 @@ func NewClient(options ...ClientOption) (*Client, error) {
 		httpClient:            &http.Client{},
 		fileUploadConcurrency: DefaultFileUploadConcurrency,
 		retryCount:            DefaultRetryCount,
+		userAgent:             userAgent,
+		maxScanTextItems:      DefaultMaxScanTextItems,
+		maxScanTextBytes:      DefaultMaxScanTextBytes,
+		scanTextConcurrency:   DefaultScanTextConcurrency,
+		scanTextWindowOverlap: DefaultScanTextWindowOverlap,
+		spoolMemoryBytes:      DefaultSpoolMemoryBytes,
+		spoolMaxBytes:         DefaultMaxSpoolBytes,
+		retryPolicy:           DefaultRetryPolicy(),
+		logger:                nopLogger{},
+		instrumentation:       NopInstrumentation{},
 	}
 
 	for _, opt := range options {
@@ -59,8 +96,11 @@ func NewClient(options ...ClientOption) (*Client, error) {
 		}
 	}
 
-	if c.apiKey == "" {
-		return nil, errMissingAPIKey
+	if c.credentials == nil {
+		if c.apiKey == "" {
+			return nil, errMissingAPIKey
+		}
+		c.credentials = StaticCredentials(c.apiKey)
 	}
 
 	return c, nil
@@ -93,6 +133,73 @@ func OptionFileUploadConcurrency(fileUploadConcurrency int) func(*Client) error
 	}
 }
 
+// OptionBaseURL sets the base URL of the Nightfall API used by the Nightfall client, for example to target a
+// regional endpoint, a proxy gateway, or a local test server. Paths such as "v3/scan" are resolved relative to it.
+func OptionBaseURL(baseURL string) func(*Client) error {
+	return func(c *Client) error {
+		u, err := url.Parse(baseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return errInvalidBaseURL
+		}
+		if !strings.HasSuffix(baseURL, "/") {
+			baseURL += "/"
+		}
+		c.baseURL = baseURL
+		return nil
+	}
+}
+
+// OptionRetryCount sets the maximum number of times the Nightfall client retries a failed request. Zero disables
+// retries.
+func OptionRetryCount(retryCount int) func(*Client) error {
+	return func(c *Client) error {
+		if retryCount < 0 {
+			return errInvalidRetryCount
+		}
+		c.retryCount = retryCount
+		return nil
+	}
+}
+
+// OptionUserAgent appends an application-specific product token, such as "my-app/1.2.3", to the User-Agent header
+// sent by the Nightfall client.
+func OptionUserAgent(suffix string) func(*Client) error {
+	return func(c *Client) error {
+		suffix = strings.TrimSpace(suffix)
+		if strings.IndexFunc(suffix, unicode.IsControl) >= 0 {
+			return errInvalidUserAgent
+		}
+		if suffix != "" {
+			c.userAgent = userAgent + " " + suffix
+		}
+		return nil
+	}
+}
+
+// OptionTimeout sets the default timeout of each text scan made by the Nightfall client, including retries. Zero
+// means no timeout. Timeouts for file scans are set per request with ScanFileRequest.Timeout.
+func OptionTimeout(timeout time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if timeout < 0 {
+			return errInvalidTimeout
+		}
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// OptionRetryPolicy sets the policy that decides how long the Nightfall client waits between retries of
+// failed requests
+func OptionRetryPolicy(policy RetryPolicy) func(*Client) error {
+	return func(c *Client) error {
+		if policy == nil {
+			return errMissingRetryPolicy
+		}
+		c.retryPolicy = policy
+		return nil
+	}
+}
+
 func loadUserAgent() string {
 	prefix := "nightfall-go-sdk"
 
@@ -110,17 +217,21 @@ func loadUserAgent() string {
 }
 
 type requestParams struct {
-	method  string
-	url     string
-	body    []byte
-	headers map[string]string
+	operation Operation
+	method    string
+	url       string
+	body      []byte
+	headers   map[string]string
+	// idempotent marks requests that can safely be repeated even if the server may have already processed them
+	idempotent bool
+	// observe, if set, is called with the outcome of every attempt
+	observe func(resp *Response, err error, latency time.Duration)
 }
 
 func (c *Client) defaultHeaders() map[string]string {
 	headers := map[string]string{
-		"Content-Type":  "application/json",
-		"Authorization": "Bearer " + c.apiKey,
-		"User-Agent":    userAgent,
+		"Content-Type": "application/json",
+		"User-Agent":   c.userAgent,
 	}
 	return headers
 }
@@ -129,8 +240,7 @@ func (c *Client) chunkedUploadHeaders(o int64) map[string]string {
 	headers := map[string]string{
 		"X-Upload-Offset": strconv.FormatInt(o, 10),
 		"Content-Type":    "application/octet-stream",
-		"Authorization":   "Bearer " + c.apiKey,
-		"User-Agent":      userAgent,
+		"User-Agent":      c.userAgent,
 	}
 	return headers
 }
@@ -155,72 +265,198 @@ func encodeBodyAsJSON(body interface{}) ([]byte, error) {
 }
 
 func (c *Client) do(ctx context.Context, reqParams requestParams, retResp interface{}) error {
-	for attempt := 1; attempt <= c.retryCount+1; attempt++ {
-		req, err := http.NewRequestWithContext(ctx, reqParams.method, reqParams.url, bytes.NewReader(reqParams.body))
+	rt := c.chain(func(ctx context.Context, req *Request) (*Response, error) {
+		return c.send(ctx, req, retResp)
+	})
+
+	start := time.Now()
+	refreshedCredentials := false
+	for attempt := 1; ; attempt++ {
+		apiKey, err := c.credentials.APIKey(ctx)
 		if err != nil {
 			return err
 		}
-		for k, v := range reqParams.headers {
-			req.Header.Set(k, v)
-		}
-		err = func() error {
-			resp, err := c.httpClient.Do(req)
-			if err != nil {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
-					return err
-				}
-			}
-			defer resp.Body.Close()
-
-			err = checkResponse(resp)
-			if err != nil {
-				if resp.StatusCode == http.StatusTooManyRequests {
-					if attempt >= c.retryCount+1 {
-						// We've hit the retry count limit, so just return the error
-						return err
-					}
-					return errRetryable429
-				}
-				return err
-			}
-
-			// Request was successful so read response if any then return
-			if retResp != nil {
-				err = json.NewDecoder(resp.Body).Decode(retResp)
-				if errors.Is(err, io.EOF) {
-					err = nil
-				}
+		resp, err := c.attempt(ctx, rt, reqParams, attempt, apiKey)
+		if err == nil {
+			return nil
+		}
+		var apiErr *Error
+		if errors.As(err, &apiErr) {
+			apiErr.Retries = attempt - 1
+		}
+		if statusCode(resp) == http.StatusUnauthorized && !refreshedCredentials && attempt <= c.retryCount {
+			// The key may have been rotated since it was cached, so retry once if a fresh one is available
+			refreshedCredentials = true
+			if c.refreshCredentials(ctx, apiKey) {
+				continue
 			}
+		}
+		if ctx.Err() != nil || !isRetryable(resp, err, reqParams.idempotent) || errors.Is(err, ErrQuotaExceeded) ||
+			attempt > c.retryCount {
+			// Either the error is not retryable or we've hit the retry count limit, so just return the error
+			return err
+		}
 
+		wait, ok := c.retryPolicy.Backoff(attempt+1, time.Since(start), resp.httpResponse())
+		if !ok {
 			return err
-		}()
-		if err == nil {
-			break
-		} else if errors.Is(err, errRetryable429) {
-			// Sleep for 1s then retry on 429's
-			time.Sleep(time.Second)
-			continue
+		}
+		c.logger.Warn("retrying nightfall request", "operation", reqParams.operation, "attempt", attempt,
+			"status", statusCode(resp), "backoff", wait, "error", err.Error())
+		c.instrumentation.RequestRetried(ctx, reqParams.operation, attempt, wait, err)
+		if sleepErr := sleepContext(ctx, wait); sleepErr != nil {
+			return sleepErr
+		}
+	}
+}
+
+// attempt makes a single attempt of a request, subject to the client's circuit breaker and rate limits, and reports
+// its outcome to the client's observers.
+func (c *Client) attempt(ctx context.Context, rt RoundTrip, reqParams requestParams, attempt int, apiKey string) (*Response, error) {
+	if c.breaker != nil {
+		if err := c.breaker.allow(); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.waitForRateLimit(ctx, reqParams); err != nil {
+		if c.breaker != nil {
+			c.breaker.cancel()
+		}
+		return nil, err
+	}
+
+	attemptCtx := c.instrumentation.RequestStarted(ctx, reqParams.operation, attempt)
+	attemptStart := time.Now()
+	req := reqParams.request(attempt)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := rt(attemptCtx, req)
+	latency := time.Since(attemptStart)
+
+	c.instrumentation.RequestFinished(attemptCtx, reqParams.operation, attempt, statusCode(resp), latency, err)
+	c.logAttempt(reqParams.operation, attempt, resp, err, latency)
+	if reqParams.observe != nil {
+		reqParams.observe(resp, err, latency)
+	}
+	if c.breaker != nil {
+		if ctx.Err() != nil {
+			// Cancellation by the caller says nothing about the health of the API
+			c.breaker.cancel()
 		} else {
-			return err
+			c.breaker.record(isCircuitFailure(resp, err))
+		}
+	}
+	return resp, err
+}
+
+// refreshCredentials asks the client's credential provider to replace a rejected key, and reports whether a
+// different key is now available.
+func (c *Client) refreshCredentials(ctx context.Context, rejectedKey string) bool {
+	r, ok := c.credentials.(CredentialRefresher)
+	if !ok {
+		return false
+	}
+	if err := r.Refresh(ctx, rejectedKey); err != nil {
+		c.logger.Warn("failed to refresh nightfall credentials", "error", err.Error())
+		return false
+	}
+	apiKey, err := c.credentials.APIKey(ctx)
+	if err != nil || apiKey == rejectedKey {
+		return false
+	}
+	c.logger.Info("refreshed nightfall credentials after unauthorized response")
+	return true
+}
+
+func (c *Client) logAttempt(op Operation, attempt int, resp *Response, err error, latency time.Duration) {
+	if err != nil {
+		c.logger.Debug("nightfall request failed", "operation", op, "attempt", attempt, "status", statusCode(resp),
+			"latency", latency, "error", err.Error())
+		return
+	}
+	c.logger.Debug("nightfall request succeeded", "operation", op, "attempt", attempt, "status", statusCode(resp),
+		"latency", latency)
+}
+
+func statusCode(resp *Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func (p requestParams) request(attempt int) *Request {
+	header := make(http.Header, len(p.headers))
+	for k, v := range p.headers {
+		header.Set(k, v)
+	}
+	return &Request{
+		Operation: p.operation,
+		Attempt:   attempt,
+		Method:    p.method,
+		URL:       p.url,
+		Header:    header,
+		Body:      p.body,
+	}
+}
+
+// send makes a single HTTP request. The returned response is non-nil whenever the server responded, even if
+// the response represents an error.
+func (c *Client) send(ctx context.Context, r *Request, retResp interface{}) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+			return nil, err
 		}
 	}
+	defer resp.Body.Close()
+
+	result := &Response{StatusCode: resp.StatusCode, Header: resp.Header}
+	err = checkResponse(resp)
+	if err != nil {
+		return result, err
+	}
 
-	return nil
+	// Request was successful so read response if any then return
+	if retResp != nil {
+		err = json.NewDecoder(resp.Body).Decode(retResp)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		result.Result = retResp
+	}
+
+	return result, err
 }
 
 // Error is the struct returned by Nightfall API requests that are unsuccessful. This struct is generally returned
-// when the HTTP status code is outside the range 200-299.
+// when the HTTP status code is outside the range 200-299. Use errors.Is with the exported sentinel errors, such as
+// ErrUnauthorized or ErrRateLimited, to classify it.
 type Error struct {
 	Code           int               `json:"code"`
 	Message        string            `json:"message"`
 	Description    string            `json:"description"`
 	AdditionalData map[string]string `json:"additionalData"`
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int `json:"-"`
+	// RequestID is the identifier the Nightfall API assigned to the request, if any. Include it when contacting support.
+	RequestID string `json:"-"`
+	// Retries is the number of times the request was retried before giving up.
+	Retries int `json:"-"`
 }
 
 func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("nightfall api returned status %d", e.StatusCode)
+	}
 	return e.Message
 }
 
@@ -229,7 +465,10 @@ func checkResponse(r *http.Response) error {
 		return nil
 	}
 
-	e := &Error{}
+	e := &Error{
+		StatusCode: r.StatusCode,
+		RequestID:  r.Header.Get("X-Request-Id"),
+	}
 	b, err := ioutil.ReadAll(r.Body)
 	if err != nil || len(b) == 0 {
 		e.Code = r.StatusCode
@@ -2,18 +2,28 @@ package nightfall
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+var testRetryPolicy = &ExponentialBackoff{
+	InitialInterval: time.Millisecond,
+	MaxInterval:     5 * time.Millisecond,
+	Multiplier:      2,
+}
+
 func TestDo(t *testing.T) {
 	var callCount int
 	tests := []struct {
-		name     string
-		handler  http.HandlerFunc
-		expCalls int
-		wantErr  bool
+		name       string
+		handler    http.HandlerFunc
+		idempotent bool
+		expCalls   int
+		wantErr    bool
 	}{
 		{
 			name: "happy path",
@@ -55,11 +65,73 @@ func TestDo(t *testing.T) {
 			expCalls: 1,
 			wantErr:  true,
 		},
+		{
+			name: "transient error - idempotent retried",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				callCount++
+				if callCount == 2 {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			idempotent: true,
+			expCalls:   2,
+			wantErr:    false,
+		},
+		{
+			name: "service unavailable - not idempotent retried",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				callCount++
+				if callCount == 2 {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			expCalls: 2,
+			wantErr:  false,
+		},
+		{
+			name: "connection dropped - idempotent retried",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				callCount++
+				if callCount == 2 {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				_ = conn.Close()
+			},
+			idempotent: true,
+			expCalls:   2,
+			wantErr:    false,
+		},
+		{
+			name: "connection dropped - not idempotent",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				callCount++
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				_ = conn.Close()
+			},
+			expCalls: 1,
+			wantErr:  true,
+		},
+		{
+			name: "bad request not retried",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				callCount++
+				w.WriteHeader(http.StatusBadRequest)
+			},
+			idempotent: true,
+			expCalls:   1,
+			wantErr:    true,
+		},
 	}
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 	defer s.Close()
 
-	client, err := NewClient(OptionAPIKey("some key"))
+	client, err := NewClient(OptionAPIKey("some key"), OptionRetryPolicy(testRetryPolicy))
 	if err != nil {
 		t.Fatal("Error initializing client")
 	}
@@ -73,6 +145,7 @@ func TestDo(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			callCount = 0
 			s.Config.Handler = test.handler
+			reqParams.idempotent = test.idempotent
 			err = client.do(context.Background(), reqParams, nil)
 			if !test.wantErr && err != nil {
 				t.Errorf("Got unexpected error: %v", err)
@@ -87,6 +160,90 @@ func TestDo(t *testing.T) {
 	}
 }
 
+func TestDoRetryAfter(t *testing.T) {
+	var callTimes []time.Time
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callTimes = append(callTimes, time.Now())
+		if len(callTimes) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionRetryPolicy(testRetryPolicy))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	err = client.do(context.Background(), requestParams{method: http.MethodPost, url: s.URL}, nil)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if len(callTimes) != 2 {
+		t.Fatalf("Expected 2 calls, got %d", len(callTimes))
+	}
+	if waited := callTimes[1].Sub(callTimes[0]); waited < time.Second {
+		t.Errorf("Expected to wait for Retry-After, only waited %v", waited)
+	}
+}
+
+func TestDoCancelDuringBackoff(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = client.do(ctx, requestParams{method: http.MethodPost, url: s.URL}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context deadline error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Backoff did not respect context cancellation")
+	}
+}
+
+func TestDoConnectionRefused(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := s.URL
+	s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionRetryPolicy(testRetryPolicy))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	var retries int
+	client.retryPolicy = retryPolicyFunc(func(attempt int, elapsed time.Duration, resp *http.Response) (time.Duration, bool) {
+		retries++
+		return time.Millisecond, true
+	})
+	err = client.do(context.Background(), requestParams{method: http.MethodPost, url: url}, nil)
+	if err == nil {
+		t.Fatal("Did not get expected error")
+	}
+	if retries != DefaultRetryCount {
+		t.Errorf("Expected %d retries of refused connection, got %d", DefaultRetryCount, retries)
+	}
+}
+
+type retryPolicyFunc func(attempt int, elapsed time.Duration, resp *http.Response) (time.Duration, bool)
+
+func (f retryPolicyFunc) Backoff(attempt int, elapsed time.Duration, resp *http.Response) (time.Duration, bool) {
+	return f(attempt, elapsed, resp)
+}
+
 func TestNewClient(t *testing.T) {
 	tests := []struct {
 		name                  string
@@ -125,3 +282,108 @@ func TestNewClient(t *testing.T) {
 		})
 	}
 }
+
+func TestClientOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		option     ClientOption
+		expBaseURL string
+		wantErr    bool
+	}{
+		{
+			name:       "base url without trailing slash",
+			option:     OptionBaseURL("https://eu.api.nightfall.ai"),
+			expBaseURL: "https://eu.api.nightfall.ai/",
+		},
+		{
+			name:       "base url with path",
+			option:     OptionBaseURL("http://localhost:8080/nightfall/"),
+			expBaseURL: "http://localhost:8080/nightfall/",
+		},
+		{
+			name:    "base url without scheme",
+			option:  OptionBaseURL("api.nightfall.ai"),
+			wantErr: true,
+		},
+		{
+			name:    "base url with query",
+			option:  OptionBaseURL("https://api.nightfall.ai/?foo=bar"),
+			wantErr: true,
+		},
+		{
+			name:    "negative retry count",
+			option:  OptionRetryCount(-1),
+			wantErr: true,
+		},
+		{
+			name:    "user agent with newline",
+			option:  OptionUserAgent("my-app/1.0\r\nX-Injected: true"),
+			wantErr: true,
+		},
+		{
+			name:    "negative timeout",
+			option:  OptionTimeout(-time.Second),
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewClient(OptionAPIKey("some key"), test.option)
+			if !test.wantErr && err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			if test.wantErr && err == nil {
+				t.Fatal("Did not get expected error")
+			}
+			if test.expBaseURL != "" && client.baseURL != test.expBaseURL {
+				t.Errorf("Expected base url %s, got %s", test.expBaseURL, client.baseURL)
+			}
+		})
+	}
+}
+
+func TestClientOptionsApplied(t *testing.T) {
+	var callCount int32
+	var agent atomic.Value
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&callCount, 1)
+		agent.Store(r.Header.Get("User-Agent"))
+		if r.URL.Path == "/slow/v3/scan" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer s.Close()
+
+	client, err := NewClient(
+		OptionAPIKey("some key"),
+		OptionBaseURL(s.URL),
+		OptionRetryPolicy(testRetryPolicy),
+		OptionRetryCount(2),
+		OptionUserAgent("my-app/1.0"),
+	)
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	_, err = client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"hello"}})
+	if err == nil {
+		t.Fatal("Did not get expected error")
+	}
+	if calls := atomic.LoadInt32(&callCount); calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+	if exp := userAgent + " my-app/1.0"; agent.Load() != exp {
+		t.Errorf("Expected user agent %q, got %q", exp, agent.Load())
+	}
+
+	client, err = NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL+"/slow"), OptionTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	_, err = client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"hello"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected default timeout to apply, got %v", err)
+	}
+}
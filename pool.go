@@ -0,0 +1,264 @@
+package nightfall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUnknownTenant is returned by a ClientPool when no member may serve the requested tenant.
+var ErrUnknownTenant = errors.New("no client configured for tenant")
+
+var errEmptyPool = errors.New("client pool must have at least one member")
+
+// RoutingStrategy decides which member of a ClientPool serves a request.
+type RoutingStrategy int
+
+const (
+	// RouteByTenant sends every request to the first member serving the tenant.
+	RouteByTenant RoutingStrategy = iota
+	// RouteRoundRobin rotates requests across all members serving the tenant.
+	RouteRoundRobin
+	// RouteLeastRecentlyRateLimited sends requests to the member serving the tenant that was rate limited the
+	// longest time ago, or never.
+	RouteLeastRecentlyRateLimited
+)
+
+// PoolMember is a Client registered in a ClientPool, typically one per API key or Nightfall workspace.
+type PoolMember struct {
+	// Name identifies the member in MemberHealth; it must be unique within the pool.
+	Name   string
+	Client *Client
+	// Tenants are the tenant IDs this member serves. Members without tenants are shared, and serve requests for
+	// any tenant that has no dedicated members.
+	Tenants []string
+}
+
+// MemberHealth is a snapshot of the health of a ClientPool member.
+type MemberHealth struct {
+	Name            string
+	Requests        int64
+	Failures        int64
+	RateLimited     int64
+	LastRateLimited time.Time
+	CircuitState    CircuitState
+}
+
+// ClientPool routes scans across several Clients, for example to isolate the usage of business units in separate
+// Nightfall workspaces, or to spread load across API keys. It is safe for concurrent use.
+type ClientPool struct {
+	members   []*poolMember
+	tenants   map[string][]*poolMember
+	shared    []*poolMember
+	strategy  RoutingStrategy
+	spillover bool
+	next      uint32
+}
+
+// ClientPoolOption defines an option for a ClientPool
+type ClientPoolOption func(*ClientPool) error
+
+type poolMember struct {
+	// Counters are accessed atomically, so they come first to keep them 64-bit aligned on 32-bit platforms
+	requests    int64
+	failures    int64
+	rateLimited int64
+	PoolMember
+
+	mu              sync.Mutex
+	lastRateLimited time.Time
+}
+
+// NewClientPool validates, then creates a ClientPool routing requests across the provided members. By default,
+// requests are routed with RouteByTenant, without spillover.
+func NewClientPool(members []PoolMember, options ...ClientPoolOption) (*ClientPool, error) {
+	if len(members) == 0 {
+		return nil, errEmptyPool
+	}
+
+	p := &ClientPool{
+		tenants:  map[string][]*poolMember{},
+		strategy: RouteByTenant,
+	}
+	names := map[string]bool{}
+	for _, m := range members {
+		if m.Client == nil {
+			return nil, fmt.Errorf("client pool member %q has no client", m.Name)
+		}
+		if names[m.Name] {
+			return nil, fmt.Errorf("duplicate client pool member %q", m.Name)
+		}
+		names[m.Name] = true
+
+		member := &poolMember{PoolMember: m}
+		p.members = append(p.members, member)
+		if len(m.Tenants) == 0 {
+			p.shared = append(p.shared, member)
+		}
+		for _, tenant := range m.Tenants {
+			p.tenants[tenant] = append(p.tenants[tenant], member)
+		}
+	}
+
+	for _, opt := range options {
+		err := opt(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// OptionPoolStrategy sets the strategy used by the ClientPool to route requests
+func OptionPoolStrategy(strategy RoutingStrategy) func(*ClientPool) error {
+	return func(p *ClientPool) error {
+		p.strategy = strategy
+		return nil
+	}
+}
+
+// OptionPoolSpillover makes the ClientPool retry a request on another member serving the same tenant when the
+// routed member is rate limited or its circuit breaker is open.
+func OptionPoolSpillover(spillover bool) func(*ClientPool) error {
+	return func(p *ClientPool) error {
+		p.spillover = spillover
+		return nil
+	}
+}
+
+// ScanText scans the provided plaintext with a member serving the tenant. See Client.ScanText.
+func (p *ClientPool) ScanText(ctx context.Context, tenantID string, request *ScanTextRequest) (*ScanTextResponse, error) {
+	var resp *ScanTextResponse
+	err := p.route(tenantID, func(c *Client) (bool, error) {
+		var err error
+		resp, err = c.ScanText(ctx, request)
+		return isSpilloverError(err), err
+	})
+	return resp, err
+}
+
+// ScanFile scans the provided file with a member serving the tenant. See Client.ScanFile. Since the file content
+// can only be read once, spillover only happens if the upload could not be initialized.
+func (p *ClientPool) ScanFile(ctx context.Context, tenantID string, request *ScanFileRequest) (*ScanFileResponse, error) {
+	var resp *ScanFileResponse
+	cleanup := func() {}
+	defer func() { cleanup() }()
+	err := p.route(tenantID, func(c *Client) (bool, error) {
+		// Content of unknown size is spooled once, so that it can still be read when spilling over
+		if request.ContentSizeBytes <= 0 {
+			content, size, release, err := c.spool(ctx, request.Content)
+			cleanup = release
+			if err != nil {
+				return false, err
+			}
+			spooled := *request
+			spooled.Content, spooled.ContentSizeBytes = content, size
+			request = &spooled
+		}
+
+		var err error
+		resp, err = c.ScanFile(ctx, request)
+		var uploadErr *UploadError
+		initFailed := errors.As(err, &uploadErr) && uploadErr.Phase == UploadPhaseInit
+		return initFailed && isSpilloverError(err), err
+	})
+	return resp, err
+}
+
+// Health returns a snapshot of the health of every member of the pool, in the order they were provided.
+func (p *ClientPool) Health() []MemberHealth {
+	health := make([]MemberHealth, 0, len(p.members))
+	for _, m := range p.members {
+		health = append(health, MemberHealth{
+			Name:            m.Name,
+			Requests:        atomic.LoadInt64(&m.requests),
+			Failures:        atomic.LoadInt64(&m.failures),
+			RateLimited:     atomic.LoadInt64(&m.rateLimited),
+			LastRateLimited: m.lastRateLimitedAt(),
+			CircuitState:    m.Client.CircuitState(),
+		})
+	}
+	return health
+}
+
+// route calls fn with members serving the tenant in order of preference until fn succeeds or reports that the
+// request must not be tried on another member.
+func (p *ClientPool) route(tenantID string, fn func(c *Client) (bool, error)) error {
+	candidates := p.candidates(tenantID)
+	if len(candidates) == 0 {
+		return fmt.Errorf("%w %q", ErrUnknownTenant, tenantID)
+	}
+
+	var err error
+	for _, m := range candidates {
+		var spill bool
+		spill, err = fn(m.Client)
+		m.record(err)
+		if err == nil || !spill || !p.spillover {
+			return err
+		}
+	}
+	return err
+}
+
+// candidates returns the members serving the tenant, ordered by the routing strategy.
+func (p *ClientPool) candidates(tenantID string) []*poolMember {
+	members, ok := p.tenants[tenantID]
+	if !ok {
+		members = p.shared
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	ordered := make([]*poolMember, 0, len(members))
+	switch p.strategy {
+	case RouteRoundRobin:
+		start := int((atomic.AddUint32(&p.next, 1) - 1) % uint32(len(members)))
+		ordered = append(ordered, members[start:]...)
+		ordered = append(ordered, members[:start]...)
+	case RouteLeastRecentlyRateLimited:
+		ordered = append(ordered, members...)
+		lastRateLimited := make(map[*poolMember]time.Time, len(ordered))
+		for _, m := range ordered {
+			lastRateLimited[m] = m.lastRateLimitedAt()
+		}
+		// Insertion sort keeps the configured order among members that were rate limited at the same time
+		for i := 1; i < len(ordered); i++ {
+			for j := i; j > 0 && lastRateLimited[ordered[j]].Before(lastRateLimited[ordered[j-1]]); j-- {
+				ordered[j], ordered[j-1] = ordered[j-1], ordered[j]
+			}
+		}
+	default:
+		ordered = append(ordered, members...)
+	}
+	return ordered
+}
+
+func (m *poolMember) record(err error) {
+	atomic.AddInt64(&m.requests, 1)
+	if err == nil {
+		return
+	}
+	atomic.AddInt64(&m.failures, 1)
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, ErrQuotaExceeded) {
+		atomic.AddInt64(&m.rateLimited, 1)
+		m.mu.Lock()
+		m.lastRateLimited = time.Now()
+		m.mu.Unlock()
+	}
+}
+
+func (m *poolMember) lastRateLimitedAt() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastRateLimited
+}
+
+func isSpilloverError(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrCircuitOpen)
+}
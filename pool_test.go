@@ -0,0 +1,235 @@
+package nightfall
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type poolTestServer struct {
+	mu    sync.Mutex
+	calls map[string]int
+	// limited are the API keys that are rate limited
+	limited map[string]bool
+}
+
+func (p *poolTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	p.calls[key]++
+	limited := p.limited[key]
+	p.mu.Unlock()
+	if limited {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	_, _ = w.Write([]byte(`{"findings":[[]],"redactedPayload":[""]}`))
+}
+
+func newTestPool(t *testing.T, url string, options ...ClientPoolOption) *ClientPool {
+	newClient := func(key string) *Client {
+		c, err := NewClient(OptionAPIKey(key), OptionBaseURL(url), OptionRetryCount(0))
+		if err != nil {
+			t.Fatal("Error initializing client")
+		}
+		return c
+	}
+	pool, err := NewClientPool([]PoolMember{
+		{Name: "finance-1", Client: newClient("finance key 1"), Tenants: []string{"finance"}},
+		{Name: "finance-2", Client: newClient("finance key 2"), Tenants: []string{"finance"}},
+		{Name: "shared", Client: newClient("shared key")},
+	}, options...)
+	if err != nil {
+		t.Fatalf("Error initializing pool: %v", err)
+	}
+	return pool
+}
+
+func TestClientPoolRouting(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []ClientPoolOption
+		limited  map[string]bool
+		tenant   string
+		requests int
+		expCalls map[string]int
+		wantErr  error
+	}{
+		{
+			name:     "by tenant",
+			tenant:   "finance",
+			requests: 2,
+			expCalls: map[string]int{"finance key 1": 2},
+		},
+		{
+			name:     "shared member serves other tenants",
+			tenant:   "marketing",
+			requests: 2,
+			expCalls: map[string]int{"shared key": 2},
+		},
+		{
+			name:     "round robin",
+			options:  []ClientPoolOption{OptionPoolStrategy(RouteRoundRobin)},
+			tenant:   "finance",
+			requests: 4,
+			expCalls: map[string]int{"finance key 1": 2, "finance key 2": 2},
+		},
+		{
+			name:     "rate limited without spillover",
+			limited:  map[string]bool{"finance key 1": true},
+			tenant:   "finance",
+			requests: 1,
+			expCalls: map[string]int{"finance key 1": 1},
+			wantErr:  ErrRateLimited,
+		},
+		{
+			name:     "rate limited with spillover",
+			options:  []ClientPoolOption{OptionPoolSpillover(true)},
+			limited:  map[string]bool{"finance key 1": true},
+			tenant:   "finance",
+			requests: 1,
+			expCalls: map[string]int{"finance key 1": 1, "finance key 2": 1},
+		},
+		{
+			name:     "least recently rate limited",
+			options:  []ClientPoolOption{OptionPoolStrategy(RouteLeastRecentlyRateLimited), OptionPoolSpillover(true)},
+			limited:  map[string]bool{"finance key 1": true},
+			tenant:   "finance",
+			requests: 3,
+			expCalls: map[string]int{"finance key 1": 1, "finance key 2": 3},
+		},
+		{
+			name:     "spillover keeps tenants isolated",
+			options:  []ClientPoolOption{OptionPoolSpillover(true)},
+			limited:  map[string]bool{"finance key 1": true, "finance key 2": true},
+			tenant:   "finance",
+			requests: 1,
+			expCalls: map[string]int{"finance key 1": 1, "finance key 2": 1},
+			wantErr:  ErrRateLimited,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &poolTestServer{calls: map[string]int{}, limited: test.limited}
+			s := httptest.NewServer(server)
+			defer s.Close()
+			pool := newTestPool(t, s.URL, test.options...)
+
+			var err error
+			for i := 0; i < test.requests; i++ {
+				_, err = pool.ScanText(context.Background(), test.tenant, &ScanTextRequest{Payload: []string{"hello"}})
+			}
+			if test.wantErr == nil && err != nil {
+				t.Errorf("Got unexpected error: %v", err)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if len(server.calls) != len(test.expCalls) {
+				t.Fatalf("Expected calls %v, got %v", test.expCalls, server.calls)
+			}
+			for key, exp := range test.expCalls {
+				if server.calls[key] != exp {
+					t.Fatalf("Expected calls %v, got %v", test.expCalls, server.calls)
+				}
+			}
+		})
+	}
+}
+
+func TestClientPoolHealth(t *testing.T) {
+	server := &poolTestServer{calls: map[string]int{}, limited: map[string]bool{"finance key 1": true}}
+	s := httptest.NewServer(server)
+	defer s.Close()
+	pool := newTestPool(t, s.URL, OptionPoolSpillover(true))
+
+	_, err := pool.ScanText(context.Background(), "finance", &ScanTextRequest{Payload: []string{"hello"}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	health := pool.Health()
+	if len(health) != 3 {
+		t.Fatalf("Expected health of 3 members, got %d", len(health))
+	}
+	if h := health[0]; h.Name != "finance-1" || h.Requests != 1 || h.Failures != 1 || h.RateLimited != 1 || h.LastRateLimited.IsZero() {
+		t.Errorf("Unexpected health of rate limited member: %+v", h)
+	}
+	if h := health[1]; h.Name != "finance-2" || h.Requests != 1 || h.Failures != 0 || !h.LastRateLimited.IsZero() {
+		t.Errorf("Unexpected health of healthy member: %+v", h)
+	}
+}
+
+func TestNewClientPool(t *testing.T) {
+	client, err := NewClient(OptionAPIKey("some key"))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	tests := []struct {
+		name    string
+		members []PoolMember
+		wantErr bool
+	}{
+		{
+			name:    "happy path",
+			members: []PoolMember{{Name: "a", Client: client}},
+		},
+		{
+			name:    "empty",
+			wantErr: true,
+		},
+		{
+			name:    "missing client",
+			members: []PoolMember{{Name: "a"}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			members: []PoolMember{{Name: "a", Client: client}, {Name: "a", Client: client}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewClientPool(test.members)
+			if !test.wantErr && err != nil {
+				t.Errorf("Got unexpected error: %v", err)
+			}
+			if test.wantErr && err == nil {
+				t.Error("Did not get expected error")
+			}
+		})
+	}
+
+	pool, _ := NewClientPool([]PoolMember{{Name: "a", Client: client, Tenants: []string{"finance"}}})
+	_, err = pool.ScanText(context.Background(), "marketing", &ScanTextRequest{})
+	if !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("Expected unknown tenant error, got %v", err)
+	}
+}
+
+func TestClientPoolRoundRobinWraparound(t *testing.T) {
+	pool := newTestPool(t, "http://localhost", OptionPoolStrategy(RouteRoundRobin))
+	pool.next = math.MaxUint32 - 1
+
+	// The counter wraps around without the start index ever going negative
+	var first []string
+	for i := 0; i < 3; i++ {
+		members := pool.candidates("finance")
+		if len(members) != 2 {
+			t.Fatalf("Expected 2 candidates, got %d", len(members))
+		}
+		first = append(first, members[0].Name)
+	}
+	if first[0] == first[1] || first[1] == first[2] {
+		t.Errorf("Expected members to alternate, got %v", first)
+	}
+}
@@ -0,0 +1,115 @@
+package nightfall
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadProgress describes the progress of ScanFile.
+type UploadProgress struct {
+	// Phase is the step of the upload in progress.
+	Phase UploadPhase
+	// BytesAcknowledged is the number of bytes uploaded so far, and TotalBytes the size of the file.
+	BytesAcknowledged int64
+	TotalBytes        int64
+	// Chunk is the index of the chunk that was just uploaded, or -1 if the progress is not about a chunk.
+	Chunk int
+	// ChunkAttempts is the number of attempts it took to upload the chunk; it is greater than 1 if the chunk was
+	// retried.
+	ChunkAttempts int
+	// Throughput is the average number of bytes uploaded per second since the upload phase started.
+	Throughput float64
+}
+
+// ProgressFunc is called with the progress of ScanFile when each phase starts and after each uploaded chunk.
+// Calls are never concurrent, even though chunks are uploaded concurrently. Returning an error cancels the scan;
+// ScanFile then returns an *UploadError wrapping that error.
+type ProgressFunc func(UploadProgress) error
+
+// progressTracker reports the progress of an upload to a ProgressFunc. A nil tracker reports nothing.
+type progressTracker struct {
+	fn     ProgressFunc
+	cancel context.CancelFunc
+
+	mu           sync.Mutex
+	current      UploadPhase
+	fileID       uuid.UUID
+	total        int64
+	acknowledged int64
+	sent         int64
+	start        time.Time
+	err          error
+}
+
+// newProgressTracker returns a tracker reporting to fn, which cancels the upload with cancel if fn returns an
+// error. It returns nil if fn is nil.
+func newProgressTracker(fn ProgressFunc, total int64, cancel context.CancelFunc) *progressTracker {
+	if fn == nil {
+		return nil
+	}
+	return &progressTracker{fn: fn, cancel: cancel, total: total}
+}
+
+// phase reports the start of a phase. acknowledged is the number of bytes already uploaded when the upload phase
+// starts, which is only non-zero for resumed uploads.
+func (p *progressTracker) phase(phase UploadPhase, fileID uuid.UUID, acknowledged int64) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = phase
+	p.fileID = fileID
+	if phase == UploadPhaseUpload {
+		p.acknowledged = acknowledged
+		p.start = time.Now()
+	}
+	p.report(UploadProgress{Chunk: -1})
+	return p.err
+}
+
+// chunk reports an uploaded chunk.
+func (p *progressTracker) chunk(index int, n int, attempts int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.acknowledged += int64(n)
+	p.sent += int64(n)
+	p.report(UploadProgress{Chunk: index, ChunkAttempts: attempts})
+}
+
+// report calls the progress func with the current state of the upload.
+func (p *progressTracker) report(progress UploadProgress) {
+	if p.err != nil {
+		return
+	}
+	progress.Phase = p.current
+	progress.BytesAcknowledged = p.acknowledged
+	progress.TotalBytes = p.total
+	if elapsed := time.Since(p.start).Seconds(); !p.start.IsZero() && elapsed > 0 {
+		progress.Throughput = float64(p.sent) / elapsed
+	}
+	if err := p.fn(progress); err != nil {
+		p.err = &UploadError{Phase: p.current, FileID: p.fileID, Err: err}
+		p.cancel()
+	}
+}
+
+// failure returns the error to surface for a failed phase: the progress func's error if it cancelled the upload,
+// and err otherwise.
+func (p *progressTracker) failure(err error) error {
+	if p == nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package nightfall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func progressTestServer(finishes *int32) *httptest.Server {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	var failed int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/upload":
+			b, _ := json.Marshal(fileUploadResponse{ID: uuid.MustParse(uuidStr), FileSizeBytes: 21, ChunkSize: 5})
+			_, _ = w.Write(b)
+		case "/v3/upload/" + uuidStr:
+			// The chunk at offset 5 fails once
+			if r.Header.Get("X-Upload-Offset") == "5" && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/v3/upload/" + uuidStr + "/finish":
+			atomic.AddInt32(finishes, 1)
+		case "/v3/upload/" + uuidStr + "/scan":
+			_, _ = w.Write([]byte(`{"id":"scan"}`))
+		}
+	}))
+}
+
+func TestScanFileProgress(t *testing.T) {
+	var finishes int32
+	s := progressTestServer(&finishes)
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionRetryPolicy(testRetryPolicy))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	// Progress calls are never concurrent, so appending needs no locking
+	var events []UploadProgress
+	_, err = client.ScanFile(context.Background(), &ScanFileRequest{
+		Content:          strings.NewReader("0123456789abcdefghijk"),
+		ContentSizeBytes: 21,
+		Progress: func(p UploadProgress) error {
+			events = append(events, p)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	var phases []string
+	var acknowledged int64
+	retried := map[int]bool{}
+	for _, e := range events {
+		if len(phases) == 0 || phases[len(phases)-1] != string(e.Phase) {
+			phases = append(phases, string(e.Phase))
+		}
+		if e.TotalBytes != 21 {
+			t.Errorf("Expected total bytes 21, got %d", e.TotalBytes)
+		}
+		if e.BytesAcknowledged < acknowledged {
+			t.Errorf("Acknowledged bytes decreased from %d to %d", acknowledged, e.BytesAcknowledged)
+		}
+		acknowledged = e.BytesAcknowledged
+		if e.Chunk >= 0 {
+			retried[e.Chunk] = e.ChunkAttempts > 1
+			if e.Throughput <= 0 {
+				t.Errorf("Expected positive throughput, got %f", e.Throughput)
+			}
+		}
+	}
+	if strings.Join(phases, ",") != "init,upload,finish,scan" {
+		t.Errorf("Unexpected phases: %v", phases)
+	}
+	if acknowledged != 21 {
+		t.Errorf("Expected 21 acknowledged bytes, got %d", acknowledged)
+	}
+	if len(retried) != 5 || !retried[1] || retried[0] || retried[2] {
+		t.Errorf("Expected 5 chunks with only chunk 1 retried, got %v", retried)
+	}
+}
+
+func TestScanFileProgressCancel(t *testing.T) {
+	var finishes int32
+	s := progressTestServer(&finishes)
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionRetryPolicy(testRetryPolicy),
+		OptionFileUploadConcurrency(1))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	stop := errors.New("stop")
+	_, err = client.ScanFile(context.Background(), &ScanFileRequest{
+		Content:          strings.NewReader("0123456789abcdefghijk"),
+		ContentSizeBytes: 21,
+		Progress: func(p UploadProgress) error {
+			if p.Chunk == 0 {
+				return stop
+			}
+			return nil
+		},
+	})
+
+	var uploadErr *UploadError
+	if !errors.As(err, &uploadErr) || !errors.Is(err, stop) {
+		t.Fatalf("Expected upload error wrapping the progress error, got %v", err)
+	}
+	if uploadErr.Phase != UploadPhaseUpload {
+		t.Errorf("Expected upload phase, got %s", uploadErr.Phase)
+	}
+	if atomic.LoadInt32(&finishes) != 0 {
+		t.Error("Expected upload not to be finished")
+	}
+}
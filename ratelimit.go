@@ -0,0 +1,141 @@
+package nightfall
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errInvalidRateLimit   = errors.New("rate limit and burst must be positive")
+	errMissingRateLimiter = errors.New("rate limiter must not be nil")
+)
+
+// RateLimiter throttles the requests made by a client.
+type RateLimiter interface {
+	// Wait blocks until n tokens are available, or returns the context's error if it is done first.
+	Wait(ctx context.Context, n int) error
+}
+
+// TokenBucket is a RateLimiter that refills tokens at a constant rate up to a maximum burst. Requests for more
+// tokens than the burst are allowed, but wait until the bucket has been repaid. It is safe for concurrent use, so a
+// single TokenBucket may be shared by several clients.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full TokenBucket that refills ratePerSecond tokens every second and holds at most
+// burst tokens. It panics if ratePerSecond or burst is not positive.
+func NewTokenBucket(ratePerSecond float64, burst int) *TokenBucket {
+	if !(ratePerSecond > 0) || burst <= 0 {
+		panic("nightfall: NewTokenBucket ratePerSecond and burst must be positive")
+	}
+	return &TokenBucket{
+		rate:   ratePerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait implements RateLimiter. Tokens are reserved in the order Wait is called, so waiters are served fairly.
+func (b *TokenBucket) Wait(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if err := sleepContext(ctx, wait); err != nil {
+		// Give back the reservation so that other waiters are not delayed by a request that never happened
+		b.mu.Lock()
+		b.tokens += float64(n)
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// OptionRateLimit limits the rate of all requests made by the Nightfall client, including retries and file chunk
+// uploads, to requestsPerSecond with bursts of up to burst requests.
+func OptionRateLimit(requestsPerSecond float64, burst int) func(*Client) error {
+	return func(c *Client) error {
+		if !(requestsPerSecond > 0) || burst <= 0 {
+			return errInvalidRateLimit
+		}
+		c.requestLimiter = NewTokenBucket(requestsPerSecond, burst)
+		return nil
+	}
+}
+
+// OptionRateLimiter limits the rate of all requests made by the Nightfall client, like OptionRateLimit, with the
+// provided limiter. A limiter such as a TokenBucket may be shared by several clients to limit them together.
+func OptionRateLimiter(limiter RateLimiter) func(*Client) error {
+	return func(c *Client) error {
+		if limiter == nil {
+			return errMissingRateLimiter
+		}
+		c.requestLimiter = limiter
+		return nil
+	}
+}
+
+// OptionTextScanRateLimit limits the rate of text scan requests made by the Nightfall client to requestsPerSecond
+// with bursts of up to burst requests. This applies in addition to any limit set by OptionRateLimit.
+func OptionTextScanRateLimit(requestsPerSecond float64, burst int) func(*Client) error {
+	return func(c *Client) error {
+		if !(requestsPerSecond > 0) || burst <= 0 {
+			return errInvalidRateLimit
+		}
+		c.textScanLimiter = NewTokenBucket(requestsPerSecond, burst)
+		return nil
+	}
+}
+
+// OptionUploadRateLimit limits the bandwidth used by the Nightfall client to upload files to bytesPerSecond with
+// bursts of up to burstBytes. This applies in addition to any limit set by OptionRateLimit.
+func OptionUploadRateLimit(bytesPerSecond float64, burstBytes int) func(*Client) error {
+	return func(c *Client) error {
+		if !(bytesPerSecond > 0) || burstBytes <= 0 {
+			return errInvalidRateLimit
+		}
+		c.uploadLimiter = NewTokenBucket(bytesPerSecond, burstBytes)
+		return nil
+	}
+}
+
+func (c *Client) waitForRateLimit(ctx context.Context, reqParams requestParams) error {
+	if c.requestLimiter != nil {
+		if err := c.requestLimiter.Wait(ctx, 1); err != nil {
+			return err
+		}
+	}
+	if c.textScanLimiter != nil && reqParams.operation == OperationScanText {
+		if err := c.textScanLimiter.Wait(ctx, 1); err != nil {
+			return err
+		}
+	}
+	if c.uploadLimiter != nil && reqParams.operation == OperationUploadChunk {
+		if err := c.uploadLimiter.Wait(ctx, len(reqParams.body)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package nightfall
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(20, 2)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := b.Wait(context.Background(), 1); err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+	}
+	// The burst of 2 is served immediately, the remaining 2 tokens take 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected to be throttled, only took %v", elapsed)
+	}
+}
+
+func TestTokenBucketLargerThanBurst(t *testing.T) {
+	b := NewTokenBucket(100, 10)
+
+	start := time.Now()
+	if err := b.Wait(context.Background(), 20); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected to wait for the deficit, only took %v", elapsed)
+	}
+}
+
+func TestTokenBucketCancel(t *testing.T) {
+	b := NewTokenBucket(1, 1)
+	if err := b.Wait(context.Background(), 1); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := b.Wait(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Wait did not respect context cancellation, took %v", elapsed)
+	}
+	if b.tokens < -0.1 {
+		t.Errorf("Expected cancelled reservation to be returned, tokens are %v", b.tokens)
+	}
+}
+
+func TestNewTokenBucketInvalid(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected rate %v to panic", rate)
+				}
+			}()
+			NewTokenBucket(rate, 1)
+		}()
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		name       string
+		option     ClientOption
+		minElapsed time.Duration
+	}{
+		{
+			name:       "all requests",
+			option:     OptionRateLimit(20, 1),
+			minElapsed: 140 * time.Millisecond,
+		},
+		{
+			name:       "shared limiter",
+			option:     OptionRateLimiter(NewTokenBucket(20, 1)),
+			minElapsed: 140 * time.Millisecond,
+		},
+		{
+			name:       "text scans",
+			option:     OptionTextScanRateLimit(20, 1),
+			minElapsed: 140 * time.Millisecond,
+		},
+		{
+			name:   "uploads do not limit text scans",
+			option: OptionUploadRateLimit(1, 1),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), test.option)
+			if err != nil {
+				t.Fatal("Error initializing client")
+			}
+
+			start := time.Now()
+			for i := 0; i < 4; i++ {
+				_, err = client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"hello"}})
+				if err != nil {
+					t.Fatalf("Got unexpected error: %v", err)
+				}
+			}
+			elapsed := time.Since(start)
+			if elapsed < test.minElapsed {
+				t.Errorf("Expected requests to take at least %v, took %v", test.minElapsed, elapsed)
+			}
+			if test.minElapsed == 0 && elapsed > time.Second {
+				t.Errorf("Expected requests not to be throttled, took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestRateLimitOptions(t *testing.T) {
+	options := []ClientOption{
+		OptionRateLimit(0, 1),
+		OptionTextScanRateLimit(1, 0),
+		OptionUploadRateLimit(-1, 1),
+		OptionRateLimit(math.NaN(), 1),
+		OptionRateLimiter(nil),
+	}
+	for _, opt := range options {
+		if _, err := NewClient(OptionAPIKey("some key"), opt); err == nil {
+			t.Error("Expected invalid rate limit to be rejected")
+		}
+	}
+}
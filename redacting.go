@@ -0,0 +1,335 @@
+package nightfall
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	errMissingRedactionConfig = errors.New("policy must configure a redaction")
+	errInvalidMaxRecordBytes  = errors.New("maxRecordBytes must be positive")
+	errNilRedactContext       = errors.New("ctx must not be nil")
+)
+
+// RedactOption defines an option for a RedactingWriter or RedactingReader
+type RedactOption func(*redactor) error
+
+// OptionRedactDelimiter sets the byte that separates records. Defaults to '\n'.
+func OptionRedactDelimiter(delim byte) func(*redactor) error {
+	return func(r *redactor) error {
+		r.delim = delim
+		return nil
+	}
+}
+
+// OptionRedactMaxRecordBytes sets the maximum size of a record. Longer records are scanned in pieces of at most
+// this size. Defaults to bufio.MaxScanTokenSize.
+func OptionRedactMaxRecordBytes(maxRecordBytes int) func(*redactor) error {
+	return func(r *redactor) error {
+		if maxRecordBytes <= 0 {
+			return errInvalidMaxRecordBytes
+		}
+		r.maxRecordBytes = maxRecordBytes
+		return nil
+	}
+}
+
+// OptionRedactFailOpen sets whether records are passed through unredacted when they cannot be scanned because the
+// API is unreachable or degraded: network errors, server errors, rate limiting and an open circuit breaker. Other
+// errors, such as an invalid API key or policy or a done context, are always returned. By default, records that
+// cannot be scanned are dropped and the error is returned, and all further reads or writes fail.
+func OptionRedactFailOpen(failOpen bool) func(*redactor) error {
+	return func(r *redactor) error {
+		r.failOpen = failOpen
+		return nil
+	}
+}
+
+// OptionRedactContext sets the context records are scanned with. Once it is done, scans fail with its error, so
+// that reads and writes waiting on the API can be cancelled. Defaults to context.Background().
+func OptionRedactContext(ctx context.Context) func(*redactor) error {
+	return func(r *redactor) error {
+		if ctx == nil {
+			return errNilRedactContext
+		}
+		r.ctx = ctx
+		return nil
+	}
+}
+
+// redactor splits data into records and redacts them.
+type redactor struct {
+	client         *Client
+	policy         *Config
+	ctx            context.Context
+	delim          byte
+	maxRecordBytes int
+	failOpen       bool
+}
+
+func newRedactor(client *Client, policy *Config, options []RedactOption) (*redactor, error) {
+	if !redacts(policy) {
+		return nil, errMissingRedactionConfig
+	}
+	r := &redactor{
+		client:         client,
+		policy:         policy,
+		ctx:            context.Background(),
+		delim:          '\n',
+		maxRecordBytes: bufio.MaxScanTokenSize,
+	}
+	for _, opt := range options {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// redacts reports whether scanning with the policy returns redacted payloads.
+func redacts(policy *Config) bool {
+	if policy == nil {
+		return false
+	}
+	if policy.DefaultRedactionConfig != nil {
+		return true
+	}
+	for _, rule := range policy.DetectionRules {
+		for _, d := range rule.Detectors {
+			if d.RedactionConfig != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redact redacts the complete records at the start of data, and returns the redacted records along with the
+// remaining data. If final is set, the remaining data is treated as a complete record as well.
+func (r *redactor) redact(data []byte, final bool) ([]byte, []byte, error) {
+	var records []string
+	var delimited []bool
+	rest := data
+	for len(rest) > 0 {
+		i := bytes.IndexByte(rest, r.delim)
+		switch {
+		case i >= 0 && i <= r.maxRecordBytes:
+			records = append(records, string(rest[:i]))
+			delimited = append(delimited, true)
+			rest = rest[i+1:]
+			continue
+		case len(rest) > r.maxRecordBytes:
+			end := r.maxRecordBytes
+			for end > 0 && !utf8.RuneStart(rest[end]) {
+				end--
+			}
+			if end == 0 {
+				end = r.maxRecordBytes
+			}
+			records = append(records, string(rest[:end]))
+			delimited = append(delimited, false)
+			rest = rest[end:]
+			continue
+		case final:
+			records = append(records, string(rest))
+			delimited = append(delimited, false)
+			rest = nil
+		}
+		break
+	}
+	if len(records) == 0 {
+		return nil, rest, nil
+	}
+
+	redacted, err := r.scan(records)
+	if err != nil {
+		if !r.failOpen || !unavailable(err) {
+			return nil, rest, err
+		}
+		redacted = records
+	}
+
+	var out bytes.Buffer
+	for i, record := range redacted {
+		out.WriteString(record)
+		if delimited[i] {
+			out.WriteByte(r.delim)
+		}
+	}
+	return out.Bytes(), rest, nil
+}
+
+// unavailable reports whether a scan failed because the API could not be reached or is degraded, rather than
+// because of the request, the API key or the caller's context.
+func unavailable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// scan returns the redacted version of every record. Records without findings are returned as is.
+func (r *redactor) scan(records []string) ([]string, error) {
+	// Empty records cannot contain findings, so they are not sent
+	var payload []string
+	var indexes []int
+	for i, record := range records {
+		if record != "" {
+			payload = append(payload, record)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(payload) == 0 {
+		return records, nil
+	}
+
+	resp, err := r.client.ScanText(r.ctx, &ScanTextRequest{Payload: payload, Policy: r.policy})
+	if err != nil {
+		return nil, err
+	}
+	redacted := make([]string, len(records))
+	copy(redacted, records)
+	for j, i := range indexes {
+		if j < len(resp.Findings) && len(resp.Findings[j]) > 0 && j < len(resp.RedactedPayload) && resp.RedactedPayload[j] != "" {
+			redacted[i] = resp.RedactedPayload[j]
+		}
+	}
+	return redacted, nil
+}
+
+// RedactingWriter is an io.Writer that redacts the data written to it with Nightfall before writing it to the
+// underlying writer. Data is split into records, by default lines; every Write call scans the records it
+// completes, so wrapping a RedactingWriter in a bufio.Writer reduces the number of API requests. A trailing
+// incomplete record is scanned by Close.
+type RedactingWriter struct {
+	w        io.Writer
+	redactor *redactor
+
+	mu     sync.Mutex
+	buf    []byte
+	err    error
+	closed bool
+}
+
+// NewRedactingWriter returns a RedactingWriter that writes data to w after redacting it with the client, using the
+// provided policy. The policy must configure how findings are redacted, through its DefaultRedactionConfig or the
+// redaction configs of its detectors.
+func NewRedactingWriter(w io.Writer, client *Client, policy *Config, options ...RedactOption) (*RedactingWriter, error) {
+	r, err := newRedactor(client, policy, options)
+	if err != nil {
+		return nil, err
+	}
+	return &RedactingWriter{w: w, redactor: r}, nil
+}
+
+// Write implements io.Writer.
+func (rw *RedactingWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.err != nil {
+		return 0, rw.err
+	}
+	if rw.closed {
+		return 0, io.ErrClosedPipe
+	}
+	rw.buf = append(rw.buf, p...)
+	if err := rw.flush(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close scans and writes any buffered data. It does not close the underlying writer.
+func (rw *RedactingWriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.closed {
+		return rw.err
+	}
+	rw.closed = true
+	if rw.err != nil {
+		return rw.err
+	}
+	return rw.flush(true)
+}
+
+func (rw *RedactingWriter) flush(final bool) error {
+	out, rest, err := rw.redactor.redact(rw.buf, final)
+	if err == nil && len(out) > 0 {
+		_, err = rw.w.Write(out)
+	}
+	if err != nil {
+		rw.err = err
+		rw.buf = nil
+		return err
+	}
+	rw.buf = append(rw.buf[:0], rest...)
+	return nil
+}
+
+// RedactingReader is an io.Reader that redacts the data read from an underlying reader with Nightfall. Data is
+// split into records, by default lines, which are scanned as they are read.
+type RedactingReader struct {
+	r        io.Reader
+	redactor *redactor
+	buf      []byte
+	pending  []byte
+	out      []byte
+	err      error
+}
+
+// NewRedactingReader returns a RedactingReader that reads data from r and redacts it with the client, using the
+// provided policy. The policy must configure how findings are redacted, through its DefaultRedactionConfig or the
+// redaction configs of its detectors.
+func NewRedactingReader(r io.Reader, client *Client, policy *Config, options ...RedactOption) (*RedactingReader, error) {
+	red, err := newRedactor(client, policy, options)
+	if err != nil {
+		return nil, err
+	}
+	return &RedactingReader{r: r, redactor: red}, nil
+}
+
+// Read implements io.Reader.
+func (rr *RedactingReader) Read(p []byte) (int, error) {
+	if rr.buf == nil {
+		rr.buf = make([]byte, 32*1024)
+	}
+	for len(rr.out) == 0 && rr.err == nil {
+		n, err := rr.r.Read(rr.buf)
+		rr.pending = append(rr.pending, rr.buf[:n]...)
+
+		out, rest, scanErr := rr.redactor.redact(rr.pending, err != nil)
+		rr.pending = append(rr.pending[:0], rest...)
+		rr.out = append(rr.out, out...)
+		switch {
+		case scanErr != nil:
+			rr.err = scanErr
+		case err != nil:
+			rr.err = err
+		}
+	}
+
+	if len(rr.out) > 0 {
+		n := copy(p, rr.out)
+		rr.out = rr.out[n:]
+		return n, nil
+	}
+	return 0, rr.err
+}
@@ -0,0 +1,160 @@
+package nightfall
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var testRedactionPolicy = &Config{
+	DefaultRedactionConfig: &RedactionConfig{SubstitutionConfig: &SubstitutionConfig{SubstitutionPhrase: "[REDACTED]"}},
+}
+
+func TestRedactingWriter(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	var out bytes.Buffer
+	w, err := NewRedactingWriter(&out, client, testRedactionPolicy)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	for _, p := range []string{"hello\nmy sec", "ret is safe\n", "\nbye ", "secret"} {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatalf("Got unexpected error writing: %v", err)
+		}
+	}
+	if out.String() != "hello\n[my secret is safe]\n\n" {
+		t.Errorf("Unexpected output before close: %q", out.String())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Got unexpected error closing: %v", err)
+	}
+	if out.String() != "hello\n[my secret is safe]\n\n[bye secret]" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+	if _, err := w.Write([]byte("more")); err == nil {
+		t.Error("Expected error writing to closed writer")
+	}
+}
+
+func TestRedactingWriterFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		failOpen bool
+		expErr   bool
+		expected string
+	}{
+		{name: "fail closed", status: http.StatusInternalServerError, failOpen: false, expErr: true, expected: ""},
+		{name: "fail open", status: http.StatusInternalServerError, failOpen: true, expected: "my secret\n"},
+		{name: "fail open unauthorized", status: http.StatusUnauthorized, failOpen: true, expErr: true, expected: ""},
+		{name: "fail open bad request", status: http.StatusBadRequest, failOpen: true, expErr: true, expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer s.Close()
+
+			client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionRetryPolicy(testRetryPolicy))
+			if err != nil {
+				t.Fatal("Error initializing client")
+			}
+			var out bytes.Buffer
+			w, err := NewRedactingWriter(&out, client, testRedactionPolicy, OptionRedactFailOpen(tt.failOpen))
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			_, err = w.Write([]byte("my secret\n"))
+			if (err != nil) != tt.expErr {
+				t.Errorf("Expected error %v, got %v", tt.expErr, err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("Expected output %q, got %q", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestRedactingWriterContext(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	// A done context is not a reason to pass records through unredacted
+	w, err := NewRedactingWriter(&out, client, testRedactionPolicy, OptionRedactContext(ctx), OptionRedactFailOpen(true))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if _, err := w.Write([]byte("my secret\n")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected canceled error, got %v", err)
+	}
+	if out.Len() != 0 || calls != 0 {
+		t.Errorf("Expected nothing to be scanned or written, got %d requests and %q", calls, out.String())
+	}
+
+	var nilCtx context.Context
+	if _, err := NewRedactingWriter(&out, client, testRedactionPolicy, OptionRedactContext(nilCtx)); !errors.Is(err, errNilRedactContext) {
+		t.Errorf("Expected nil context error, got %v", err)
+	}
+}
+
+func TestRedactingReader(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	input := "a;my secret;b;last secret"
+	r, err := NewRedactingReader(iotest.OneByteReader(strings.NewReader(input)), client, testRedactionPolicy,
+		OptionRedactDelimiter(';'))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if string(out) != "a;[my secret];b;[last secret]" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestRedactingMissingRedactionConfig(t *testing.T) {
+	client, err := NewClient(OptionAPIKey("some key"))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	if _, err := NewRedactingWriter(io.Discard, client, &Config{}); !errors.Is(err, errMissingRedactionConfig) {
+		t.Errorf("Expected missing redaction config error, got %v", err)
+	}
+	if _, err := NewRedactingReader(strings.NewReader(""), client, nil); !errors.Is(err, errMissingRedactionConfig) {
+		t.Errorf("Expected missing redaction config error, got %v", err)
+	}
+}
@@ -0,0 +1,353 @@
+package nightfall
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+var errNotResumable = errors.New("content must implement io.ReaderAt or io.Seeker to resume an upload")
+
+// UploadCheckpoint records the progress of a file upload, so that an interrupted upload can be resumed.
+type UploadCheckpoint struct {
+	ID            uuid.UUID `json:"id"`
+	FileSizeBytes int64     `json:"fileSizeBytes"`
+	ChunkSize     int64     `json:"chunkSize"`
+	// Offsets are the offsets of the chunks that were uploaded, in increasing order.
+	Offsets []int64 `json:"offsets"`
+	// Finished is set once the upload is finished.
+	Finished bool `json:"finished"`
+	// Fingerprint is a hash of the file, so that the upload is only resumed with the same file.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// CheckpointStore persists upload checkpoints by key.
+type CheckpointStore interface {
+	// Load returns the checkpoint saved under key, or nil if there is none.
+	Load(ctx context.Context, key string) (*UploadCheckpoint, error)
+	// Save saves the checkpoint under key, replacing any earlier checkpoint.
+	Save(ctx context.Context, key string, checkpoint *UploadCheckpoint) error
+	// Delete deletes the checkpoint saved under key, if any.
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryCheckpointStore is a CheckpointStore that keeps checkpoints in memory. It allows resuming uploads that
+// failed within the same process.
+type MemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[string]UploadCheckpoint
+}
+
+// NewMemoryCheckpointStore returns an empty MemoryCheckpointStore.
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: map[string]UploadCheckpoint{}}
+}
+
+// Load implements CheckpointStore.
+func (s *MemoryCheckpointStore) Load(ctx context.Context, key string) (*UploadCheckpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoint, ok := s.checkpoints[key]
+	if !ok {
+		return nil, nil
+	}
+	checkpoint.Offsets = append([]int64(nil), checkpoint.Offsets...)
+	return &checkpoint, nil
+}
+
+// Save implements CheckpointStore.
+func (s *MemoryCheckpointStore) Save(ctx context.Context, key string, checkpoint *UploadCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := *checkpoint
+	saved.Offsets = append([]int64(nil), checkpoint.Offsets...)
+	s.checkpoints[key] = saved
+	return nil
+}
+
+// Delete implements CheckpointStore.
+func (s *MemoryCheckpointStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checkpoints, key)
+	return nil
+}
+
+// FileCheckpointStore is a CheckpointStore that saves every checkpoint as a JSON file in a directory. It allows
+// resuming uploads after a restart.
+type FileCheckpointStore struct {
+	dir string
+}
+
+// NewFileCheckpointStore returns a FileCheckpointStore saving checkpoints in dir, creating the directory if needed.
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileCheckpointStore{dir: dir}, nil
+}
+
+func (s *FileCheckpointStore) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key)+".json")
+}
+
+// Load implements CheckpointStore.
+func (s *FileCheckpointStore) Load(ctx context.Context, key string) (*UploadCheckpoint, error) {
+	b, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	checkpoint := &UploadCheckpoint{}
+	if err := json.Unmarshal(b, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// Save implements CheckpointStore. The checkpoint is written to a temporary file first, so that a crash while
+// saving does not corrupt the previous checkpoint.
+func (s *FileCheckpointStore) Save(ctx context.Context, key string, checkpoint *UploadCheckpoint) error {
+	b, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path(key))
+}
+
+// Delete implements CheckpointStore.
+func (s *FileCheckpointStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// contentFingerprint returns the hex-encoded SHA-256 hash of the first size bytes of content.
+func contentFingerprint(content io.Reader, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.CopyN(h, content, size); err == io.EOF {
+		return "", errContentSizeMismatch
+	} else if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ResumeUpload uploads and scans a file like ScanFile, recording its progress in store under key. If a checkpoint
+// for the file is found, the upload is resumed: only the chunks that were not uploaded yet are read and uploaded.
+// The request's Content must therefore implement io.ReaderAt or io.Seeker; chunks are read relative to its position
+// when ResumeUpload is called. If ContentSizeBytes is zero or less, the size is determined by seeking to the end of
+// the content, which must then implement io.Seeker. The content is hashed before uploading, and a checkpoint is
+// only resumed if it was saved for content with the same hash. If the API no longer knows the upload session of a
+// checkpoint, for instance because it expired, the upload starts over. The checkpoint is deleted once the scan is
+// started.
+func (c *Client) ResumeUpload(ctx context.Context, store CheckpointStore, key string, request *ScanFileRequest) (*ScanFileResponse, error) {
+	// Chunks are read relative to the current position of the content
+	seeker, isSeeker := request.Content.(io.Seeker)
+	var base int64
+	if isSeeker {
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, errNotResumable
+		}
+		base = pos
+	}
+	if request.ContentSizeBytes <= 0 {
+		// Content of unknown size cannot be spooled, since the spool would not survive to resume from
+		if !isSeeker {
+			return nil, errNotResumable
+		}
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := seeker.Seek(base, io.SeekStart); err != nil {
+			return nil, err
+		}
+		sized := *request
+		sized.ContentSizeBytes = end - base
+		request = &sized
+	}
+	readerAt, isReaderAt := contentReaderAt(request.Content, request.ContentSizeBytes)
+	if ra, ok := request.Content.(io.ReaderAt); ok && !isSeeker {
+		readerAt, isReaderAt = io.NewSectionReader(ra, 0, request.ContentSizeBytes), true
+	}
+	if !isReaderAt && !isSeeker {
+		return nil, errNotResumable
+	}
+
+	var cancel context.CancelFunc
+	if request.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, request.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	progress := newProgressTracker(request.Progress, request.ContentSizeBytes, cancel)
+
+	// Chunks are read at any offset, so that only the missing ones are read
+	read := func(session *UploadSession, offset int64) ([]byte, error) {
+		if isReaderAt {
+			return session.readChunkAt(readerAt, offset)
+		}
+		buf := make([]byte, session.chunkLen(offset))
+		if _, err := seeker.Seek(base+offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(request.Content, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return buf, nil
+	}
+
+	var fingerprint string
+	var err error
+	if isReaderAt {
+		fingerprint, err = contentFingerprint(io.NewSectionReader(readerAt, 0, request.ContentSizeBytes), request.ContentSizeBytes)
+	} else if _, err = seeker.Seek(base, io.SeekStart); err == nil {
+		fingerprint, err = contentFingerprint(request.Content, request.ContentSizeBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		resp, resumed, err := c.resumeUpload(ctx, store, key, request, fingerprint, progress, read, isReaderAt)
+		if err == nil || !errors.Is(err, ErrNotFound) {
+			return resp, err
+		}
+		// The upload session expired or is unknown to the API, so the checkpoint is of no further use
+		if delErr := store.Delete(ctx, key); delErr != nil {
+			return nil, delErr
+		}
+		if !resumed {
+			return nil, err
+		}
+		c.logger.Warn("upload session not found, restarting upload", "key", key, "error", err.Error())
+	}
+}
+
+// resumeUpload uploads and scans a file, resuming from the checkpoint saved under key if it is for the same
+// content. It reports whether the upload was resumed, rather than started from scratch.
+func (c *Client) resumeUpload(ctx context.Context, store CheckpointStore, key string, request *ScanFileRequest,
+	fingerprint string, progress *progressTracker, read func(session *UploadSession, offset int64) ([]byte, error),
+	parallel bool) (*ScanFileResponse, bool, error) {
+	checkpoint, err := store.Load(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+	resumed := checkpoint != nil && checkpoint.FileSizeBytes == request.ContentSizeBytes &&
+		checkpoint.Fingerprint == fingerprint
+	if !resumed {
+		if err := progress.phase(UploadPhaseInit, uuid.Nil, 0); err != nil {
+			return nil, false, err
+		}
+		session, err := c.InitUpload(ctx, request.ContentSizeBytes)
+		if err != nil {
+			return nil, false, progress.failure(err)
+		}
+		checkpoint = &UploadCheckpoint{ID: session.ID, FileSizeBytes: session.FileSizeBytes, ChunkSize: session.ChunkSize,
+			Fingerprint: fingerprint}
+		if err := store.Save(ctx, key, checkpoint); err != nil {
+			return nil, false, err
+		}
+	} else {
+		c.logger.Info("resuming file upload", "upload_id", checkpoint.ID.String(), "chunks", len(checkpoint.Offsets))
+	}
+	session := c.OpenUploadSession(checkpoint.ID, checkpoint.FileSizeBytes, checkpoint.ChunkSize)
+	if session.ChunkSize <= 0 {
+		return nil, resumed, progress.failure(&UploadError{Phase: UploadPhaseUpload, FileID: session.ID, Err: errInvalidChunk})
+	}
+
+	if !checkpoint.Finished {
+		acked := map[int64]bool{}
+		var acknowledged int64
+		for _, offset := range checkpoint.Offsets {
+			acked[offset] = true
+			acknowledged += session.chunkLen(offset)
+		}
+		var missing []int64
+		for _, offset := range session.chunkOffsets() {
+			if !acked[offset] {
+				missing = append(missing, offset)
+			}
+		}
+
+		// Checkpoints are saved one at a time, so that a slow store cannot save an older checkpoint last
+		var mu sync.Mutex
+		var saveErr error
+		save := func(offset int64, n int, attempts int) {
+			progress.chunk(int(offset/session.ChunkSize), n, attempts)
+			mu.Lock()
+			defer mu.Unlock()
+			checkpoint.Offsets = append(checkpoint.Offsets, offset)
+			sort.Slice(checkpoint.Offsets, func(i, j int) bool { return checkpoint.Offsets[i] < checkpoint.Offsets[j] })
+			if err := store.Save(ctx, key, checkpoint); err != nil && saveErr == nil {
+				saveErr = err
+			}
+		}
+		if err := progress.phase(UploadPhaseUpload, session.ID, acknowledged); err != nil {
+			return nil, resumed, err
+		}
+		readChunk := func(offset int64) ([]byte, error) {
+			return read(session, offset)
+		}
+		if err := c.uploadChunks(ctx, session, missing, readChunk, save, parallel); err != nil {
+			return nil, resumed, progress.failure(err)
+		}
+		if saveErr != nil {
+			return nil, resumed, saveErr
+		}
+
+		if err := progress.phase(UploadPhaseFinish, session.ID, 0); err != nil {
+			return nil, resumed, err
+		}
+		if err := session.Finish(ctx); err != nil {
+			return nil, resumed, progress.failure(err)
+		}
+		checkpoint.Finished = true
+		if err := store.Save(ctx, key, checkpoint); err != nil {
+			return nil, resumed, err
+		}
+	}
+
+	if err := progress.phase(UploadPhaseScan, session.ID, 0); err != nil {
+		return nil, resumed, err
+	}
+	resp, err := session.Scan(ctx, request)
+	if err != nil {
+		return nil, resumed, progress.failure(err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		c.logger.Warn("failed to delete upload checkpoint", "upload_id", session.ID.String(), "error", err.Error())
+	}
+	return resp, resumed, nil
+}
@@ -0,0 +1,283 @@
+package nightfall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// seekOnly hides every method of the underlying reader but Read and Seek.
+type seekOnly struct {
+	io.ReadSeeker
+}
+
+func TestResumeUpload(t *testing.T) {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	content := []byte("0123456789abcdefghijk")
+
+	for _, tt := range []struct {
+		name    string
+		content func() io.Reader
+		size    int64
+	}{
+		{name: "reader at", content: func() io.Reader { return bytes.NewReader(content) }, size: int64(len(content))},
+		{name: "seeker", content: func() io.Reader { return seekOnly{bytes.NewReader(content)} }, size: int64(len(content))},
+		{name: "unknown size", content: func() io.Reader { return bytes.NewReader(content) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var inits, finishes int
+			failOffset := int64(10)
+			uploaded := map[int64]string{}
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch r.URL.Path {
+				case "/v3/upload":
+					inits++
+					req := &fileUploadRequest{}
+					_ = json.NewDecoder(r.Body).Decode(req)
+					b, _ := json.Marshal(fileUploadResponse{ID: uuid.MustParse(uuidStr), FileSizeBytes: req.FileSizeBytes, ChunkSize: 5})
+					_, _ = w.Write(b)
+				case "/v3/upload/" + uuidStr:
+					offset, _ := strconv.ParseInt(r.Header.Get("X-Upload-Offset"), 10, 64)
+					if offset == failOffset {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					b, _ := io.ReadAll(r.Body)
+					if _, ok := uploaded[offset]; ok {
+						t.Errorf("Chunk at offset %d uploaded twice", offset)
+					}
+					uploaded[offset] = string(b)
+				case "/v3/upload/" + uuidStr + "/finish":
+					finishes++
+				case "/v3/upload/" + uuidStr + "/scan":
+					_, _ = w.Write([]byte(`{"id":"scan"}`))
+				}
+			}))
+			defer s.Close()
+
+			client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionFileUploadConcurrency(1))
+			if err != nil {
+				t.Fatal("Error initializing client")
+			}
+			store := NewMemoryCheckpointStore()
+			ctx := context.Background()
+			request := &ScanFileRequest{Content: tt.content(), ContentSizeBytes: tt.size}
+
+			if _, err := client.ResumeUpload(ctx, store, "file", request); !errors.Is(err, ErrUploadFailed) {
+				t.Fatalf("Expected upload to fail, got %v", err)
+			}
+			checkpoint, err := store.Load(ctx, "file")
+			if err != nil || checkpoint == nil {
+				t.Fatalf("Expected checkpoint to be saved, got %v, %v", checkpoint, err)
+			}
+			if !reflect.DeepEqual(checkpoint.Offsets, []int64{0, 5}) || checkpoint.Finished {
+				t.Errorf("Unexpected checkpoint: %+v", checkpoint)
+			}
+
+			mu.Lock()
+			failOffset = -1
+			mu.Unlock()
+			request.Content = tt.content()
+			resp, err := client.ResumeUpload(ctx, store, "file", request)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			if resp.ID != "scan" {
+				t.Errorf("Unexpected scan response: %+v", resp)
+			}
+
+			if inits != 1 || finishes != 1 {
+				t.Errorf("Expected 1 init and finish, got %d and %d", inits, finishes)
+			}
+			var got []byte
+			for offset := int64(0); offset < int64(len(content)); offset += 5 {
+				got = append(got, uploaded[offset]...)
+			}
+			if string(got) != string(content) {
+				t.Errorf("Expected uploaded content %q, got %q", content, got)
+			}
+			if checkpoint, _ := store.Load(ctx, "file"); checkpoint != nil {
+				t.Errorf("Expected checkpoint to be deleted, got %+v", checkpoint)
+			}
+		})
+	}
+}
+
+func TestResumeUploadRestart(t *testing.T) {
+	content := []byte("0123456789abcdefghijk")
+	other := []byte("abcdefghijk0123456789")
+	fingerprint, err := contentFingerprint(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	otherFingerprint, err := contentFingerprint(bytes.NewReader(other), int64(len(other)))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	known := uuid.MustParse("430d42aa-1e1f-405d-8799-7f5f26486a0d")
+
+	for _, tt := range []struct {
+		name       string
+		checkpoint UploadCheckpoint
+	}{
+		{
+			name:       "expired session",
+			checkpoint: UploadCheckpoint{ID: uuid.New(), FileSizeBytes: 21, ChunkSize: 5, Offsets: []int64{0, 5}, Fingerprint: fingerprint},
+		},
+		{
+			name:       "other content",
+			checkpoint: UploadCheckpoint{ID: known, FileSizeBytes: 21, ChunkSize: 5, Offsets: []int64{0, 5}, Fingerprint: otherFingerprint},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var inits int
+			sessions := map[string]map[int64]string{known.String(): {}}
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.URL.Path == "/v3/upload" {
+					inits++
+					id := uuid.New()
+					sessions[id.String()] = map[int64]string{}
+					b, _ := json.Marshal(fileUploadResponse{ID: id, FileSizeBytes: 21, ChunkSize: 5})
+					_, _ = w.Write(b)
+					return
+				}
+				parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v3/upload/"), "/")
+				uploaded, ok := sessions[parts[0]]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if len(parts) == 1 {
+					offset, _ := strconv.ParseInt(r.Header.Get("X-Upload-Offset"), 10, 64)
+					b, _ := io.ReadAll(r.Body)
+					uploaded[offset] = string(b)
+				} else if parts[1] == "scan" {
+					var got []byte
+					for offset := int64(0); offset < int64(len(content)); offset += 5 {
+						got = append(got, uploaded[offset]...)
+					}
+					_, _ = w.Write([]byte(`{"id":"` + string(got) + `"}`))
+				}
+			}))
+			defer s.Close()
+
+			client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+			if err != nil {
+				t.Fatal("Error initializing client")
+			}
+			store := NewMemoryCheckpointStore()
+			ctx := context.Background()
+			if err := store.Save(ctx, "file", &tt.checkpoint); err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+
+			request := &ScanFileRequest{Content: bytes.NewReader(content), ContentSizeBytes: int64(len(content))}
+			resp, err := client.ResumeUpload(ctx, store, "file", request)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			if inits != 1 {
+				t.Errorf("Expected the upload to start over, got %d inits", inits)
+			}
+			if resp.ID != string(content) {
+				t.Errorf("Expected uploaded content %q, got %q", content, resp.ID)
+			}
+			if checkpoint, _ := store.Load(ctx, "file"); checkpoint != nil {
+				t.Errorf("Expected checkpoint to be deleted, got %+v", checkpoint)
+			}
+		})
+	}
+}
+
+func TestResumeUploadNotResumable(t *testing.T) {
+	client, err := NewClient(OptionAPIKey("some key"))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	for _, content := range []io.Reader{io.MultiReader(bytes.NewReader(nil)), r} {
+		request := &ScanFileRequest{Content: content, ContentSizeBytes: 10}
+		if _, err := client.ResumeUpload(context.Background(), NewMemoryCheckpointStore(), "file", request); !errors.Is(err, errNotResumable) {
+			t.Errorf("Expected not resumable error for %T, got %v", content, err)
+		}
+	}
+}
+
+func TestResumeUploadInvalidChunkSize(t *testing.T) {
+	client, err := NewClient(OptionAPIKey("some key"))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	ctx := context.Background()
+	store := NewMemoryCheckpointStore()
+	fingerprint, err := contentFingerprint(bytes.NewReader(make([]byte, 10)), 10)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	checkpoint := &UploadCheckpoint{ID: uuid.MustParse("430d42aa-1e1f-405d-8799-7f5f26486a0d"), FileSizeBytes: 10, Fingerprint: fingerprint}
+	if err := store.Save(ctx, "file", checkpoint); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	request := &ScanFileRequest{Content: bytes.NewReader(make([]byte, 10)), ContentSizeBytes: 10}
+	if _, err := client.ResumeUpload(ctx, store, "file", request); !errors.Is(err, errInvalidChunk) {
+		t.Errorf("Expected invalid chunk error, got %v", err)
+	}
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	store, err := NewFileCheckpointStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	ctx := context.Background()
+	key := "dir/file.bin"
+
+	if checkpoint, err := store.Load(ctx, key); checkpoint != nil || err != nil {
+		t.Errorf("Expected no checkpoint, got %v, %v", checkpoint, err)
+	}
+	checkpoint := &UploadCheckpoint{ID: uuid.New(), FileSizeBytes: 100, ChunkSize: 10, Offsets: []int64{0, 20}}
+	if err := store.Save(ctx, key, checkpoint); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	loaded, err := store.Load(ctx, key)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, checkpoint) {
+		t.Errorf("Expected checkpoint %+v, got %+v", checkpoint, loaded)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Expected deleting a missing checkpoint to succeed, got %v", err)
+	}
+	if checkpoint, err := store.Load(ctx, key); checkpoint != nil || err != nil {
+		t.Errorf("Expected no checkpoint after delete, got %v, %v", checkpoint, err)
+	}
+}
@@ -0,0 +1,182 @@
+package nightfall
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultInitialRetryInterval = 500 * time.Millisecond
+	DefaultMaxRetryInterval     = 30 * time.Second
+	DefaultRetryMultiplier      = 2.0
+	DefaultRetryJitter          = 0.5
+	DefaultMaxRetryElapsedTime  = 2 * time.Minute
+)
+
+// RetryPolicy decides how long the client waits before retrying a failed request. A Client consults its
+// policy after every retryable failure, up to the configured retry count. Rate limited requests, refused
+// connections and 503 responses are always retryable; other 5xx responses, timeouts and dropped connections
+// are only retried for requests that are safe to repeat, namely uploading a file chunk and scanning text.
+type RetryPolicy interface {
+	// Backoff returns how long to wait before making the given attempt, where attempt 2 is the first retry.
+	// elapsed is the time spent on the request so far, and resp is the failed response, which may be nil
+	// when no response was received. Returning false stops retrying and surfaces the last error.
+	Backoff(attempt int, elapsed time.Duration, resp *http.Response) (time.Duration, bool)
+}
+
+// ExponentialBackoff is a RetryPolicy that waits an exponentially increasing, randomized interval between
+// attempts. If the server indicates when the request may be retried through the Retry-After or
+// X-RateLimit-Reset headers, that hint takes precedence over the computed interval.
+type ExponentialBackoff struct {
+	// InitialInterval is the wait before the first retry.
+	InitialInterval time.Duration
+	// MaxInterval caps the computed wait between two attempts.
+	MaxInterval time.Duration
+	// Multiplier is the factor the interval grows by after each attempt.
+	Multiplier float64
+	// Jitter is the randomization factor in the range [0,1]; an interval i is picked uniformly from
+	// [i*(1-Jitter), i*(1+Jitter)] so that concurrent callers do not retry in lockstep.
+	Jitter float64
+	// MaxElapsedTime is the total time after which no further retries are attempted. Zero means no limit.
+	MaxElapsedTime time.Duration
+}
+
+// isRetryable classifies a failed attempt. Failures that guarantee the request was not processed, such as rate
+// limiting or a refused connection, are always retryable. Failures after which the server may or may not have
+// processed the request, such as gateway errors or a connection dropped mid-response, are only retryable when
+// repeating the request is harmless.
+func isRetryable(resp *Response, err error, idempotent bool) bool {
+	if resp != nil {
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return true
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+			return idempotent
+		default:
+			return false
+		}
+	}
+
+	if err == nil {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.Is(err, syscall.ECONNREFUSED) || (errors.As(err, &opErr) && opErr.Op == "dial") {
+		return true
+	}
+	if !idempotent {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// DefaultRetryPolicy returns the ExponentialBackoff used by clients that do not configure a RetryPolicy.
+func DefaultRetryPolicy() *ExponentialBackoff {
+	return &ExponentialBackoff{
+		InitialInterval: DefaultInitialRetryInterval,
+		MaxInterval:     DefaultMaxRetryInterval,
+		Multiplier:      DefaultRetryMultiplier,
+		Jitter:          DefaultRetryJitter,
+		MaxElapsedTime:  DefaultMaxRetryElapsedTime,
+	}
+}
+
+// Backoff implements RetryPolicy.
+func (b *ExponentialBackoff) Backoff(attempt int, elapsed time.Duration, resp *http.Response) (time.Duration, bool) {
+	wait, ok := retryAfter(resp, time.Now())
+	if !ok {
+		wait = b.interval(attempt)
+	}
+	if b.MaxElapsedTime > 0 && elapsed+wait > b.MaxElapsedTime {
+		return 0, false
+	}
+	return wait, true
+}
+
+func (b *ExponentialBackoff) interval(attempt int) time.Duration {
+	retry := attempt - 1
+	if retry < 1 {
+		retry = 1
+	}
+	interval := float64(b.InitialInterval) * math.Pow(b.Multiplier, float64(retry-1))
+	if b.MaxInterval > 0 && interval > float64(b.MaxInterval) {
+		interval = float64(b.MaxInterval)
+	}
+	if b.Jitter > 0 {
+		delta := b.Jitter * interval
+		interval = interval - delta + rand.Float64()*(2*delta)
+	}
+	return time.Duration(interval)
+}
+
+// retryAfter extracts the server's hint for when a request may be retried. Retry-After may hold either a
+// number of seconds or an HTTP date. X-RateLimit-Reset may hold a number of seconds, a unix timestamp or an
+// HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	if d, ok := parseResetHeader(resp.Header.Get("Retry-After"), now); ok {
+		return d, true
+	}
+	return parseResetHeader(resp.Header.Get("X-RateLimit-Reset"), now)
+}
+
+func parseResetHeader(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		// Values this large can only be unix timestamps rather than a relative number of seconds
+		if secs > 1e9 {
+			return nonNegative(time.Unix(int64(secs), 0).Sub(now)), true
+		}
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return nonNegative(t.Sub(now)), true
+	}
+	return 0, false
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// sleepContext pauses for the provided duration, returning early with the context's error if it is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,132 @@
+package nightfall
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := &ExponentialBackoff{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		Multiplier:      2,
+		MaxElapsedTime:  10 * time.Second,
+	}
+
+	tests := []struct {
+		name    string
+		attempt int
+		elapsed time.Duration
+		header  http.Header
+		expWait time.Duration
+		expOK   bool
+	}{
+		{
+			name:    "first retry",
+			attempt: 2,
+			expWait: 100 * time.Millisecond,
+			expOK:   true,
+		},
+		{
+			name:    "third retry",
+			attempt: 4,
+			expWait: 400 * time.Millisecond,
+			expOK:   true,
+		},
+		{
+			name:    "capped at max interval",
+			attempt: 10,
+			expWait: time.Second,
+			expOK:   true,
+		},
+		{
+			name:    "retry after seconds",
+			attempt: 2,
+			header:  http.Header{"Retry-After": []string{"3"}},
+			expWait: 3 * time.Second,
+			expOK:   true,
+		},
+		{
+			name:    "rate limit reset seconds",
+			attempt: 2,
+			header:  http.Header{"X-Ratelimit-Reset": []string{"2"}},
+			expWait: 2 * time.Second,
+			expOK:   true,
+		},
+		{
+			name:    "max elapsed time exceeded",
+			attempt: 3,
+			elapsed: 9950 * time.Millisecond,
+			expOK:   false,
+		},
+		{
+			name:    "retry after exceeds max elapsed time",
+			attempt: 2,
+			header:  http.Header{"Retry-After": []string{"60"}},
+			expOK:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resp *http.Response
+			if test.header != nil {
+				resp = &http.Response{Header: test.header}
+			}
+			wait, ok := b.Backoff(test.attempt, test.elapsed, resp)
+			if ok != test.expOK {
+				t.Fatalf("Expected ok %v, got %v", test.expOK, ok)
+			}
+			if ok && wait != test.expWait {
+				t.Errorf("Expected wait %v, got %v", test.expWait, wait)
+			}
+		})
+	}
+}
+
+func TestExponentialBackoffJitter(t *testing.T) {
+	b := &ExponentialBackoff{
+		InitialInterval: time.Second,
+		Multiplier:      2,
+		Jitter:          0.5,
+	}
+	for i := 0; i < 100; i++ {
+		wait, ok := b.Backoff(2, 0, nil)
+		if !ok {
+			t.Fatal("Expected retry to be allowed")
+		}
+		if wait < 500*time.Millisecond || wait > 1500*time.Millisecond {
+			t.Fatalf("Jittered wait %v outside of expected range", wait)
+		}
+	}
+}
+
+func TestParseResetHeader(t *testing.T) {
+	now := time.Date(2021, 10, 4, 17, 30, 43, 0, time.UTC)
+	tests := []struct {
+		name    string
+		value   string
+		expWait time.Duration
+		expOK   bool
+	}{
+		{name: "empty", value: "", expOK: false},
+		{name: "seconds", value: "5", expWait: 5 * time.Second, expOK: true},
+		{name: "unix timestamp", value: "1633368653", expWait: 10 * time.Second, expOK: true},
+		{name: "http date", value: "Mon, 04 Oct 2021 17:31:43 GMT", expWait: time.Minute, expOK: true},
+		{name: "date in the past", value: "Mon, 04 Oct 2021 17:29:43 GMT", expWait: 0, expOK: true},
+		{name: "garbage", value: "soon", expOK: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wait, ok := parseResetHeader(test.value, now)
+			if ok != test.expOK {
+				t.Fatalf("Expected ok %v, got %v", test.expOK, ok)
+			}
+			if wait != test.expWait {
+				t.Errorf("Expected wait %v, got %v", test.expWait, wait)
+			}
+		})
+	}
+}
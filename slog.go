@@ -0,0 +1,422 @@
+//go:build go1.21
+
+package nightfall
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultSlogBatchSize is the default maximum number of log records redacted together by a SlogHandler.
+	DefaultSlogBatchSize = 100
+	// DefaultSlogFlushInterval is the default maximum time a SlogHandler holds on to a log record before redacting it.
+	DefaultSlogFlushInterval = 100 * time.Millisecond
+	// DefaultSlogQueueSize is the default number of log records a SlogHandler queues for redaction.
+	DefaultSlogQueueSize = 1000
+	// DefaultSlogCacheSize is the default number of redacted strings a SlogHandler remembers.
+	DefaultSlogCacheSize = 1000
+)
+
+var errSlogHandlerClosed = errors.New("slog handler closed")
+
+// SlogHandlerConfig configures a SlogHandler.
+type SlogHandlerConfig struct {
+	// Policy is the policy log records are scanned with. It must configure how findings are redacted.
+	Policy *Config
+	// BatchSize is the maximum number of log records redacted with a single request. Defaults to
+	// DefaultSlogBatchSize.
+	BatchSize int
+	// FlushInterval is the maximum time a log record waits for its batch to fill up before it is redacted. Defaults
+	// to DefaultSlogFlushInterval.
+	FlushInterval time.Duration
+	// QueueSize is the number of log records that may wait to be redacted. Records logged while the queue is full
+	// are dropped, unless BlockOnFullQueue is set. Defaults to DefaultSlogQueueSize.
+	QueueSize int
+	// BlockOnFullQueue makes logging wait for room in the queue instead of dropping the record.
+	BlockOnFullQueue bool
+	// CacheSize is the number of strings whose redacted version is remembered, so that repeated messages and
+	// values are not scanned again. Defaults to DefaultSlogCacheSize; a negative size disables the cache.
+	CacheSize int
+	// FailOpen forwards log records unredacted when they cannot be scanned. By default, such records are dropped.
+	FailOpen bool
+	// OnError, if set, is called when log records cannot be scanned.
+	OnError func(error)
+}
+
+// SlogHandler is a slog.Handler that redacts the message and string attribute values of log records with
+// Nightfall before passing them on to another handler. Records are queued and redacted in batches in the
+// background, so logging does not wait for the API; Close must be called to flush the queue. Attributes added
+// with WithAttrs are redacted in the background as well, along with the first records logged with them.
+type SlogHandler struct {
+	root  slog.Handler
+	inner *slogInner
+	core  *slogCore
+}
+
+// slogInner is the handler a SlogHandler passes records on to. The handlers derived with WithAttrs and WithGroup
+// are built by the background goroutine once their attributes are redacted, so that deriving a handler does not
+// wait for the API.
+type slogInner struct {
+	parent *slogInner
+	attrs  []slog.Attr
+	group  string
+	// handler is set for the root, and by the background goroutine once the attributes are redacted
+	handler slog.Handler
+}
+
+// slogCore is shared by a SlogHandler and the handlers derived from it.
+type slogCore struct {
+	// dropped is accessed atomically, so it comes first to keep it 64-bit aligned on 32-bit platforms
+	dropped int64
+
+	client *Client
+	config SlogHandlerConfig
+	queue  chan slogEntry
+	done   chan struct{}
+	cache  *stringCache
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+type slogEntry struct {
+	ctx    context.Context
+	inner  *slogInner
+	record slog.Record
+}
+
+// NewSlogHandler returns a SlogHandler that passes redacted log records on to inner.
+func NewSlogHandler(inner slog.Handler, client *Client, config SlogHandlerConfig) (*SlogHandler, error) {
+	if !redacts(config.Policy) {
+		return nil, errMissingRedactionConfig
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultSlogBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultSlogFlushInterval
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultSlogQueueSize
+	}
+	if config.CacheSize == 0 {
+		config.CacheSize = DefaultSlogCacheSize
+	}
+
+	core := &slogCore{
+		client: client,
+		config: config,
+		queue:  make(chan slogEntry, config.QueueSize),
+		done:   make(chan struct{}),
+		cache:  newStringCache(config.CacheSize),
+	}
+	go core.run()
+	return &SlogHandler{root: inner, inner: &slogInner{handler: inner}, core: core}, nil
+}
+
+// Enabled implements slog.Handler.
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.root.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler by queueing the record for redaction.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.core.mu.RLock()
+	defer h.core.mu.RUnlock()
+	if h.core.closed {
+		return errSlogHandlerClosed
+	}
+
+	entry := slogEntry{ctx: context.WithoutCancel(ctx), inner: h.inner, record: r.Clone()}
+	if h.core.config.BlockOnFullQueue {
+		select {
+		case h.core.queue <- entry:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	select {
+	case h.core.queue <- entry:
+	default:
+		atomic.AddInt64(&h.core.dropped, 1)
+	}
+	return nil
+}
+
+// WithAttrs implements slog.Handler. The attributes are redacted in the background with the records logged with
+// them.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	inner := &slogInner{parent: h.inner, attrs: append([]slog.Attr(nil), attrs...)}
+	return &SlogHandler{root: h.root, inner: inner, core: h.core}
+}
+
+// WithGroup implements slog.Handler.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &SlogHandler{root: h.root, inner: &slogInner{parent: h.inner, group: name}, core: h.core}
+}
+
+// Dropped returns the number of log records dropped because the queue was full.
+func (h *SlogHandler) Dropped() int64 {
+	return atomic.LoadInt64(&h.core.dropped)
+}
+
+// Close redacts and passes on the queued log records, and stops the handler and the handlers derived from it.
+// Records logged after Close are rejected. Close returns early with the context's error if it is done first.
+func (h *SlogHandler) Close(ctx context.Context) error {
+	h.core.mu.Lock()
+	if !h.core.closed {
+		h.core.closed = true
+		close(h.core.queue)
+	}
+	h.core.mu.Unlock()
+
+	select {
+	case <-h.core.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run redacts queued log records in batches until the queue is closed.
+func (c *slogCore) run() {
+	defer close(c.done)
+
+	batch := make([]slogEntry, 0, c.config.BatchSize)
+	timer := time.NewTimer(c.config.FlushInterval)
+	timer.Stop()
+	defer timer.Stop()
+
+	flush := func() {
+		timer.Stop()
+		c.flush(batch)
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case entry, ok := <-c.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(c.config.FlushInterval)
+			}
+			batch = append(batch, entry)
+			if len(batch) >= c.config.BatchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// flush redacts a batch of log records with a single request and passes them on.
+func (c *slogCore) flush(batch []slogEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	var values []string
+	seen := map[*slogInner]bool{}
+	for _, entry := range batch {
+		// The attributes of derived handlers that are not built yet are redacted with the records
+		for n := entry.inner; n.handler == nil && !seen[n]; n = n.parent {
+			seen[n] = true
+			for _, a := range n.attrs {
+				values = appendAttrStrings(values, a)
+			}
+		}
+		values = append(values, entry.record.Message)
+		entry.record.Attrs(func(a slog.Attr) bool {
+			values = appendAttrStrings(values, a)
+			return true
+		})
+	}
+
+	redacted, err := c.redact(context.Background(), values)
+	if err != nil {
+		c.reportError(err)
+		if !c.config.FailOpen {
+			return
+		}
+		redacted = nil
+	}
+	// Handlers built from unredacted attributes are not kept, so that later batches redact them again
+	keep := err == nil
+
+	for _, entry := range batch {
+		r := entry.record
+		message := r.Message
+		if v, ok := redacted[message]; ok {
+			message = v
+		}
+		out := slog.NewRecord(r.Time, r.Level, message, r.PC)
+		r.Attrs(func(a slog.Attr) bool {
+			out.AddAttrs(mapAttrStrings(a, redacted))
+			return true
+		})
+		if err := entry.inner.resolve(redacted, keep).Handle(entry.ctx, out); err != nil {
+			c.reportError(err)
+		}
+	}
+}
+
+// resolve returns the handler, building it and its parents with the redacted attributes if they are not built yet.
+// The handlers it builds are kept for later records if keep is set. It must only be called by the background
+// goroutine.
+func (n *slogInner) resolve(redacted map[string]string, keep bool) slog.Handler {
+	if n.handler != nil {
+		return n.handler
+	}
+	parent := n.parent.resolve(redacted, keep)
+	var handler slog.Handler
+	if n.group != "" {
+		handler = parent.WithGroup(n.group)
+	} else {
+		mapped := make([]slog.Attr, len(n.attrs))
+		for i, a := range n.attrs {
+			mapped[i] = mapAttrStrings(a, redacted)
+		}
+		handler = parent.WithAttrs(mapped)
+	}
+	if keep {
+		n.handler = handler
+	}
+	return handler
+}
+
+// redact returns the redacted version of the values that have findings, consulting the cache first.
+func (c *slogCore) redact(ctx context.Context, values []string) (map[string]string, error) {
+	redacted := map[string]string{}
+	var payload []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		if r, ok := c.cache.get(v); ok {
+			redacted[v] = r
+			continue
+		}
+		payload = append(payload, v)
+	}
+	if len(payload) == 0 {
+		return redacted, nil
+	}
+
+	resp, err := c.client.ScanText(ctx, &ScanTextRequest{Payload: payload, Policy: c.config.Policy})
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range payload {
+		r := v
+		if i < len(resp.Findings) && len(resp.Findings[i]) > 0 && i < len(resp.RedactedPayload) && resp.RedactedPayload[i] != "" {
+			r = resp.RedactedPayload[i]
+		}
+		redacted[v] = r
+		c.cache.put(v, r)
+	}
+	return redacted, nil
+}
+
+func (c *slogCore) reportError(err error) {
+	if c.config.OnError != nil {
+		c.config.OnError(err)
+	}
+}
+
+// appendAttrStrings appends the string values of an attribute, including those nested in groups.
+func appendAttrStrings(values []string, a slog.Attr) []string {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		values = append(values, v.String())
+	case slog.KindGroup:
+		for _, ga := range v.Group() {
+			values = appendAttrStrings(values, ga)
+		}
+	}
+	return values
+}
+
+// mapAttrStrings replaces the string values of an attribute with their redacted version.
+func mapAttrStrings(a slog.Attr, redacted map[string]string) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		if r, ok := redacted[v.String()]; ok {
+			return slog.String(a.Key, r)
+		}
+		return slog.Attr{Key: a.Key, Value: v}
+	case slog.KindGroup:
+		group := v.Group()
+		mapped := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			mapped[i] = mapAttrStrings(ga, redacted)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(mapped...)}
+	default:
+		return slog.Attr{Key: a.Key, Value: v}
+	}
+}
+
+// stringCache is a least recently used cache of strings.
+type stringCache struct {
+	size  int
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+}
+
+type cacheItem struct {
+	key, value string
+}
+
+func newStringCache(size int) *stringCache {
+	return &stringCache{size: size, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *stringCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheItem).value, true
+}
+
+func (c *stringCache) put(key, value string) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*cacheItem).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheItem{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheItem).key)
+	}
+}
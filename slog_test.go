@@ -0,0 +1,217 @@
+//go:build go1.21
+
+package nightfall
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSlogHandler(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	var out bytes.Buffer
+	inner := slog.NewTextHandler(&out, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	h, err := NewSlogHandler(inner, client, SlogHandlerConfig{Policy: testRedactionPolicy, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	logger := slog.New(h).With("token", "secret token")
+	if calls != 0 {
+		t.Errorf("Expected attributes to be redacted with the records, got %d requests", calls)
+	}
+	logger.Info("my secret", "count", 3, slog.Group("user", "name", "alice", "password", "secret pw"))
+	logger.Info("my secret", "other", "secret token")
+	logger.WithGroup("req").With("id", "secret id").Info("done")
+	if err := h.Close(context.Background()); err != nil {
+		t.Fatalf("Got unexpected error closing: %v", err)
+	}
+
+	expected := `level=INFO msg="[my secret]" token="[secret token]" count=3 user.name=alice user.password="[secret pw]"
+level=INFO msg="[my secret]" token="[secret token]" other="[secret token]"
+level=INFO msg=done token="[secret token]" req.id="[secret id]"
+`
+	if out.String() != expected {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expected, out.String())
+	}
+	if calls != 1 {
+		t.Errorf("Expected records to be redacted in a single request, got %d requests", calls)
+	}
+
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "late", 0)); err == nil {
+		t.Error("Expected error logging to closed handler")
+	}
+}
+
+func TestSlogHandlerCache(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	var out bytes.Buffer
+	h, err := NewSlogHandler(slog.NewTextHandler(&out, nil), client, SlogHandlerConfig{Policy: testRedactionPolicy, BatchSize: 1})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	logger := slog.New(h)
+	for i := 0; i < 3; i++ {
+		logger.Info("my secret")
+	}
+	if err := h.Close(context.Background()); err != nil {
+		t.Fatalf("Got unexpected error closing: %v", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected repeated message to be scanned once, got %d requests", calls)
+	}
+	if strings.Count(out.String(), `msg="[my secret]"`) != 3 {
+		t.Errorf("Expected 3 redacted records, got:\n%s", out.String())
+	}
+}
+
+func TestSlogHandlerFailure(t *testing.T) {
+	var blocked int32 = 1
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&blocked) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	for _, failOpen := range []bool{false, true} {
+		var out bytes.Buffer
+		var errs int32
+		h, err := NewSlogHandler(slog.NewTextHandler(&out, nil), client, SlogHandlerConfig{
+			Policy:   testRedactionPolicy,
+			FailOpen: failOpen,
+			OnError:  func(error) { atomic.AddInt32(&errs, 1) },
+		})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		slog.New(h).With("token", "secret token").Info("my secret")
+		if err := h.Close(context.Background()); err != nil {
+			t.Fatalf("Got unexpected error closing: %v", err)
+		}
+
+		if errs != 1 {
+			t.Errorf("Expected 1 reported error, got %d", errs)
+		}
+		if logged := strings.Contains(out.String(), "my secret") && strings.Contains(out.String(), "secret token"); logged != failOpen {
+			t.Errorf("Expected record logged %v with failOpen %v, got:\n%s", failOpen, failOpen, out.String())
+		}
+	}
+}
+
+func TestSlogHandlerFailOpenRetry(t *testing.T) {
+	var failed int32
+	var calls int32
+	scan := echoScanHandler(&calls, 0)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		scan(w, r)
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	var out bytes.Buffer
+	h, err := NewSlogHandler(slog.NewTextHandler(&out, nil), client, SlogHandlerConfig{
+		Policy:    testRedactionPolicy,
+		BatchSize: 1,
+		FailOpen:  true,
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	logger := slog.New(h).With("token", "secret token")
+	logger.Info("first")
+	logger.Info("second")
+	if err := h.Close(context.Background()); err != nil {
+		t.Fatalf("Got unexpected error closing: %v", err)
+	}
+
+	// The first record is passed on unredacted, but the attributes are redacted again for the second
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `token="secret token"`) || !strings.Contains(lines[1], `token="[secret token]"`) {
+		t.Errorf("Expected the second record to be redacted, got:\n%s", out.String())
+	}
+}
+
+func TestSlogHandlerFullQueue(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		echoScanHandler(&calls, 0)(w, r)
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	var out bytes.Buffer
+	h, err := NewSlogHandler(slog.NewTextHandler(&out, nil), client, SlogHandlerConfig{
+		Policy:    testRedactionPolicy,
+		BatchSize: 1,
+		QueueSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	logger := slog.New(h)
+	for i := 0; i < 10; i++ {
+		logger.Info("message")
+	}
+	close(release)
+	if err := h.Close(context.Background()); err != nil {
+		t.Fatalf("Got unexpected error closing: %v", err)
+	}
+
+	if h.Dropped() == 0 {
+		t.Error("Expected records to be dropped while the queue was full")
+	}
+	if logged := int64(strings.Count(out.String(), "msg=message")); logged+h.Dropped() != 10 {
+		t.Errorf("Expected logged and dropped records to add up to 10, got %d and %d", logged, h.Dropped())
+	}
+}
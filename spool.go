@@ -0,0 +1,99 @@
+package nightfall
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+const (
+	// DefaultSpoolMemoryBytes is the default size up to which content of unknown size is spooled in memory.
+	DefaultSpoolMemoryBytes = 8 << 20
+	// DefaultMaxSpoolBytes is the default maximum size of content of unknown size.
+	DefaultMaxSpoolBytes = 1 << 30
+)
+
+var (
+	errInvalidSpool  = errors.New("memoryBytes must not be negative, and maxBytes must be at least memoryBytes")
+	errSpoolTooLarge = errors.New("content exceeds the maximum spool size")
+)
+
+// OptionSpool configures how ScanFile handles content of unknown size, that is requests with a ContentSizeBytes of
+// zero or less. Such content is read in full to determine its size before it is uploaded: up to memoryBytes are
+// kept in memory, and larger content is written to a temporary file in dir, or in the default directory for
+// temporary files if dir is empty. Content larger than maxBytes is rejected.
+func OptionSpool(dir string, memoryBytes, maxBytes int64) func(*Client) error {
+	return func(c *Client) error {
+		if memoryBytes < 0 || maxBytes < memoryBytes {
+			return errInvalidSpool
+		}
+		c.spoolDir = dir
+		c.spoolMemoryBytes = memoryBytes
+		c.spoolMaxBytes = maxBytes
+		return nil
+	}
+}
+
+// spool reads content in full, and returns a reader of the content along with its size. The returned function
+// releases the resources held by the reader, and must be called in every case.
+func (c *Client) spool(ctx context.Context, content io.Reader) (io.Reader, int64, func(), error) {
+	// Missing content is treated as empty, as it is when its size is known
+	if content == nil {
+		return bytes.NewReader(nil), 0, func() {}, nil
+	}
+	content = contextReader{ctx: ctx, r: content}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(content, c.spoolMemoryBytes+1))
+	if err != nil {
+		return nil, 0, func() {}, err
+	}
+	if n <= c.spoolMemoryBytes {
+		return bytes.NewReader(buf.Bytes()), n, func() {}, nil
+	}
+
+	f, err := os.CreateTemp(c.spoolDir, "nightfall-spool-*")
+	if err != nil {
+		return nil, 0, func() {}, err
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		cleanup()
+		return nil, 0, func() {}, err
+	}
+	copied, err := io.Copy(f, io.LimitReader(content, c.spoolMaxBytes-n+1))
+	if err != nil {
+		cleanup()
+		return nil, 0, func() {}, err
+	}
+	size := n + copied
+	if size > c.spoolMaxBytes {
+		cleanup()
+		return nil, 0, func() {}, errSpoolTooLarge
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, 0, func() {}, err
+	}
+	c.logger.Debug("spooled file content to disk", "path", f.Name(), "size", size)
+	return f, size, cleanup, nil
+}
+
+// contextReader is an io.Reader that stops reading once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,148 @@
+package nightfall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/uuid"
+)
+
+func TestScanFileUnknownSize(t *testing.T) {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	content := "0123456789abcdefghijk"
+
+	tests := []struct {
+		name        string
+		memoryBytes int64
+		maxBytes    int64
+		failInit    bool
+		wantErr     error
+	}{
+		{name: "memory", memoryBytes: 1024, maxBytes: 1024},
+		{name: "disk", memoryBytes: 4, maxBytes: 1024},
+		{name: "too large", memoryBytes: 4, maxBytes: 10, wantErr: errSpoolTooLarge},
+		{name: "upload failure", memoryBytes: 4, maxBytes: 1024, failInit: true, wantErr: ErrUploadFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var declared int64
+			uploaded := map[int64]string{}
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch r.URL.Path {
+				case "/v3/upload":
+					if tt.failInit {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					req := &fileUploadRequest{}
+					_ = json.NewDecoder(r.Body).Decode(req)
+					declared = req.FileSizeBytes
+					b, _ := json.Marshal(fileUploadResponse{ID: uuid.MustParse(uuidStr), FileSizeBytes: req.FileSizeBytes, ChunkSize: 5})
+					_, _ = w.Write(b)
+				case "/v3/upload/" + uuidStr:
+					offset, _ := strconv.ParseInt(r.Header.Get("X-Upload-Offset"), 10, 64)
+					b, _ := io.ReadAll(r.Body)
+					uploaded[offset] = string(b)
+				case "/v3/upload/" + uuidStr + "/scan":
+					_, _ = w.Write([]byte(`{"id":"scan"}`))
+				}
+			}))
+			defer s.Close()
+
+			dir := t.TempDir()
+			client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionSpool(dir, tt.memoryBytes, tt.maxBytes))
+			if err != nil {
+				t.Fatal("Error initializing client")
+			}
+			_, err = client.ScanFile(context.Background(), &ScanFileRequest{Content: iotest.OneByteReader(strings.NewReader(content))})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 0 {
+				t.Errorf("Expected spool directory to be empty, got %d entries", len(entries))
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if declared != int64(len(content)) {
+				t.Errorf("Expected declared size %d, got %d", len(content), declared)
+			}
+			var got string
+			for offset := int64(0); offset < int64(len(content)); offset += 5 {
+				got += uploaded[offset]
+			}
+			if got != content {
+				t.Errorf("Expected uploaded content %q, got %q", content, got)
+			}
+		})
+	}
+}
+
+func TestOptionSpool(t *testing.T) {
+	for _, tt := range []struct {
+		memoryBytes, maxBytes int64
+		wantErr               bool
+	}{
+		{memoryBytes: 0, maxBytes: 0},
+		{memoryBytes: 10, maxBytes: 100},
+		{memoryBytes: -1, maxBytes: 100, wantErr: true},
+		{memoryBytes: 100, maxBytes: 10, wantErr: true},
+	} {
+		_, err := NewClient(OptionAPIKey("some key"), OptionSpool("", tt.memoryBytes, tt.maxBytes))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("OptionSpool(%d, %d): got error %v, want error %v", tt.memoryBytes, tt.maxBytes, err, tt.wantErr)
+		}
+	}
+}
+
+func TestScanFileNilContent(t *testing.T) {
+	uuidStr := "430d42aa-1e1f-405d-8799-7f5f26486a0d"
+	var declared int64 = -1
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/upload":
+			req := &fileUploadRequest{}
+			_ = json.NewDecoder(r.Body).Decode(req)
+			declared = req.FileSizeBytes
+			b, _ := json.Marshal(fileUploadResponse{ID: uuid.MustParse(uuidStr), FileSizeBytes: req.FileSizeBytes, ChunkSize: 5})
+			_, _ = w.Write(b)
+		case "/v3/upload/" + uuidStr + "/scan":
+			_, _ = w.Write([]byte(`{"id":"scan"}`))
+		}
+	}))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+	if _, err := client.ScanFile(context.Background(), &ScanFileRequest{}); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if declared != 0 {
+		t.Errorf("Expected empty upload, got size %d", declared)
+	}
+
+	pool, err := NewClientPool([]PoolMember{{Name: "a", Client: client}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if _, err := pool.ScanFile(context.Background(), "tenant", &ScanFileRequest{}); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+}
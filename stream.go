@@ -0,0 +1,325 @@
+package nightfall
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultStreamBatchRecords is the default maximum number of records scanned in a single request by a
+	// StreamScanner.
+	DefaultStreamBatchRecords = 1000
+	// DefaultStreamFlushInterval is the default maximum time a StreamScanner holds on to a record before scanning it.
+	DefaultStreamFlushInterval = time.Second
+)
+
+// StreamConfig configures a StreamScanner.
+type StreamConfig struct {
+	// Policy and PolicyUUIDs select the policy records are scanned with, as in a ScanTextRequest.
+	Policy      *Config
+	PolicyUUIDs []string
+	// Split splits the stream into records. It defaults to bufio.ScanLines; see also SplitDelimiter and SplitSize.
+	// The offsets reported for records assume that the split function returns tokens starting at the beginning of
+	// the data it consumes, as all of these do.
+	Split bufio.SplitFunc
+	// MaxRecordBytes is the maximum size of a single record. Defaults to bufio.MaxScanTokenSize.
+	MaxRecordBytes int
+	// BatchRecords is the maximum number of records scanned in a single request. Defaults to
+	// DefaultStreamBatchRecords.
+	BatchRecords int
+	// BatchBytes is the maximum size of the records scanned in a single request. Defaults to the client's scan text
+	// limit, see OptionScanTextLimits.
+	BatchBytes int
+	// FlushInterval is the maximum time a record waits for its batch to fill up before it is scanned. Defaults to
+	// DefaultStreamFlushInterval.
+	FlushInterval time.Duration
+	// OnResult, if set, is called with the result of every record in order, instead of sending results on the
+	// Results channel. Returning an error stops the scan.
+	OnResult func(StreamResult) error
+}
+
+// StreamResult is the result of scanning one record of a stream.
+type StreamResult struct {
+	// Index is the position of the record in the stream, starting at 0.
+	Index int64
+	// Start and End are the byte offsets of the record in the stream. Locations of findings are relative to the
+	// record, so a finding starts at byte Start+Location.ByteRange.Start of the stream.
+	Start int64
+	End   int64
+	// Text is the content of the record.
+	Text string
+	// Findings are the findings in the record.
+	Findings []*Finding
+	// RedactedText is the redacted record, if the policy redacts findings and the record has any.
+	RedactedText string
+}
+
+// StreamScanner scans text read from an io.Reader without loading it in memory. The stream is split into records,
+// which are scanned in batches, and a result is produced for every record in order. Reading from the stream is
+// paused while results are not consumed.
+//
+// Results must be consumed until the Results channel is closed, or the scanner must be closed. Err reports the
+// error that stopped the scan, if any.
+type StreamScanner struct {
+	client  *Client
+	config  StreamConfig
+	reader  io.Reader
+	records chan StreamResult
+	results chan StreamResult
+	done    chan struct{}
+	cancel  context.CancelFunc
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+// NewStreamScanner starts scanning the records read from r with the client, until r is exhausted, an error
+// occurs, ctx is done or the scanner is closed.
+func NewStreamScanner(ctx context.Context, client *Client, r io.Reader, config StreamConfig) *StreamScanner {
+	if config.Split == nil {
+		config.Split = bufio.ScanLines
+	}
+	if config.MaxRecordBytes <= 0 {
+		config.MaxRecordBytes = bufio.MaxScanTokenSize
+	}
+	if config.BatchRecords <= 0 {
+		config.BatchRecords = DefaultStreamBatchRecords
+	}
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = client.maxScanTextBytes
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultStreamFlushInterval
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &StreamScanner{
+		client:  client,
+		config:  config,
+		reader:  r,
+		records: make(chan StreamResult, config.BatchRecords),
+		results: make(chan StreamResult),
+		done:    make(chan struct{}),
+		cancel:  cancel,
+	}
+	go s.read(ctx)
+	go s.scan(ctx)
+	return s
+}
+
+// Results returns the channel results are sent on. It is closed once the scan stops. No results are sent if
+// StreamConfig.OnResult is set.
+func (s *StreamScanner) Results() <-chan StreamResult {
+	return s.results
+}
+
+// Done returns a channel that is closed once the scan stops.
+func (s *StreamScanner) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that stopped the scan, or nil if the stream was scanned completely or the scan is still
+// running.
+func (s *StreamScanner) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close stops the scan and waits for it to stop, returning the error that stopped it before it was closed. Close
+// does not close the underlying reader, and a read that is in progress is not interrupted.
+func (s *StreamScanner) Close() error {
+	s.mu.Lock()
+	if s.err == nil {
+		s.closed = true
+	}
+	s.mu.Unlock()
+	s.cancel()
+	<-s.done
+	return s.Err()
+}
+
+func (s *StreamScanner) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil && !s.closed {
+		s.err = err
+	}
+	s.cancel()
+}
+
+// read splits the stream into records until it is exhausted, and closes the records channel.
+func (s *StreamScanner) read(ctx context.Context) {
+	defer close(s.records)
+
+	var consumed, start int64
+	scanner := bufio.NewScanner(s.reader)
+	scanner.Buffer(nil, s.config.MaxRecordBytes)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := s.config.Split(data, atEOF)
+		if token != nil {
+			start = consumed
+		}
+		consumed += int64(advance)
+		return advance, token, err
+	})
+
+	var index int64
+	for scanner.Scan() {
+		text := scanner.Text()
+		record := StreamResult{Index: index, Start: start, End: start + int64(len(text)), Text: text}
+		select {
+		case s.records <- record:
+		case <-ctx.Done():
+			return
+		}
+		index++
+	}
+	if err := scanner.Err(); err != nil {
+		s.fail(err)
+	}
+}
+
+// scan batches records and scans them, until all records are scanned or the scan is stopped.
+func (s *StreamScanner) scan(ctx context.Context) {
+	defer close(s.done)
+	defer close(s.results)
+	defer s.cancel()
+
+	var batch []StreamResult
+	var size int
+	timer := time.NewTimer(s.config.FlushInterval)
+	timer.Stop()
+	defer timer.Stop()
+
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		timer.Stop()
+		err := s.flush(ctx, batch)
+		batch, size = nil, 0
+		if err != nil {
+			s.fail(err)
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case record, ok := <-s.records:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					s.fail(err)
+					return
+				}
+				flush()
+				return
+			}
+			recordSize := encodedLen(record.Text) + payloadItemOverhead
+			if len(batch) > 0 && size+recordSize > s.config.BatchBytes {
+				if !flush() {
+					return
+				}
+			}
+			if len(batch) == 0 {
+				timer.Reset(s.config.FlushInterval)
+			}
+			batch = append(batch, record)
+			size += recordSize
+			if len(batch) >= s.config.BatchRecords && !flush() {
+				return
+			}
+		case <-timer.C:
+			if !flush() {
+				return
+			}
+		case <-ctx.Done():
+			s.fail(ctx.Err())
+			return
+		}
+	}
+}
+
+// flush scans a batch of records and delivers their results.
+func (s *StreamScanner) flush(ctx context.Context, batch []StreamResult) error {
+	payload := make([]string, len(batch))
+	for i, record := range batch {
+		payload[i] = record.Text
+	}
+	resp, err := s.client.ScanText(ctx, &ScanTextRequest{
+		Payload:     payload,
+		Policy:      s.config.Policy,
+		PolicyUUIDs: s.config.PolicyUUIDs,
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, record := range batch {
+		if i < len(resp.Findings) {
+			record.Findings = resp.Findings[i]
+		}
+		if i < len(resp.RedactedPayload) {
+			record.RedactedText = resp.RedactedPayload[i]
+		}
+		if s.config.OnResult != nil {
+			if err := s.config.OnResult(record); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case s.results <- record:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// SplitDelimiter returns a split function for StreamConfig that splits a stream into records separated by delim.
+func SplitDelimiter(delim byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		for i, b := range data {
+			if b == delim {
+				return i + 1, data[:i], nil
+			}
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// SplitSize returns a split function for StreamConfig that splits a stream into records of size bytes. Records are
+// shortened as needed to not split a UTF-8 encoded rune. It panics if size is not positive.
+func SplitSize(size int) bufio.SplitFunc {
+	if size <= 0 {
+		panic("nightfall: SplitSize size must be positive")
+	}
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) < size {
+			if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		}
+		end := size
+		last := end - 1
+		for last > 0 && last > end-utf8.UTFMax && !utf8.RuneStart(data[last]) {
+			last--
+		}
+		if last > 0 && !utf8.FullRune(data[last:end]) {
+			end = last
+		}
+		return end, data[:end], nil
+	}
+}
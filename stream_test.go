@@ -0,0 +1,150 @@
+package nightfall
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamScanner(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	input := "first line\nsecret line\n\nlast secret"
+	scanner := NewStreamScanner(context.Background(), client, strings.NewReader(input), StreamConfig{BatchRecords: 2})
+
+	var results []StreamResult
+	for result := range scanner.Results() {
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(results))
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 requests, got %d", calls)
+	}
+	for i, result := range results {
+		if result.Index != int64(i) {
+			t.Errorf("Expected result %d to have index %d, got %d", i, i, result.Index)
+		}
+		if input[result.Start:result.End] != result.Text {
+			t.Errorf("Offsets %d-%d of result %d do not locate %q", result.Start, result.End, i, result.Text)
+		}
+		secret := strings.Contains(result.Text, "secret")
+		if secret != (len(result.Findings) == 1) {
+			t.Errorf("Unexpected findings for %q: %v", result.Text, result.Findings)
+		}
+		if secret && result.RedactedText != "["+result.Text+"]" {
+			t.Errorf("Unexpected redacted text for %q: %q", result.Text, result.RedactedText)
+		}
+	}
+}
+
+func TestStreamScannerCallback(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	stop := errors.New("stop")
+	var seen []string
+	scanner := NewStreamScanner(context.Background(), client, strings.NewReader("a;b;c;d"), StreamConfig{
+		Split: SplitDelimiter(';'),
+		OnResult: func(result StreamResult) error {
+			seen = append(seen, result.Text)
+			if result.Text == "c" {
+				return stop
+			}
+			return nil
+		},
+	})
+	<-scanner.Done()
+
+	if err := scanner.Err(); !errors.Is(err, stop) {
+		t.Errorf("Expected callback error, got %v", err)
+	}
+	if strings.Join(seen, ";") != "a;b;c" {
+		t.Errorf("Unexpected records: %v", seen)
+	}
+}
+
+func TestStreamScannerFlushInterval(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	r, w := io.Pipe()
+	defer w.Close()
+	scanner := NewStreamScanner(context.Background(), client, r, StreamConfig{FlushInterval: 10 * time.Millisecond})
+
+	go func() {
+		_, _ = w.Write([]byte("a secret\n"))
+	}()
+	select {
+	case result := <-scanner.Results():
+		if result.Text != "a secret" || len(result.Findings) != 1 {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected record to be flushed before the stream ends")
+	}
+
+	if err := scanner.Close(); err != nil {
+		t.Errorf("Got unexpected error closing scanner: %v", err)
+	}
+	if _, ok := <-scanner.Results(); ok {
+		t.Error("Expected results channel to be closed")
+	}
+}
+
+func TestSplitSize(t *testing.T) {
+	input := "abcé漢字xyz"
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	scanner.Split(SplitSize(4))
+
+	var records []string
+	for scanner.Scan() {
+		records = append(records, scanner.Text())
+	}
+	expected := []string{"abc", "é", "漢", "字x", "yz"}
+	if strings.Join(records, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected records %q, got %q", expected, records)
+	}
+}
+
+func TestSplitSizeInvalid(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected size %d to panic", size)
+				}
+			}()
+			SplitSize(size)
+		}()
+	}
+}
@@ -0,0 +1,224 @@
+package nightfall
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	errMissingTable   = errors.New("request has no table")
+	errMissingHeader  = errors.New("columns cannot be selected in a table without a header")
+	errUnknownColumns = errors.New("unknown columns")
+)
+
+// Table is a table of text cells, such as the contents of a CSV file.
+type Table struct {
+	// Header holds the column names. It may be empty.
+	Header []string
+	// Rows holds the cells of every row, excluding the header. Rows may have different lengths.
+	Rows [][]string
+}
+
+// ReadCSVTable reads a table from CSV data, with comma as the field delimiter; use '\t' for TSV data. If header is
+// set, the first record is used as the header.
+func ReadCSVTable(r io.Reader, comma rune, header bool) (*Table, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{Rows: records}
+	if header && len(records) > 0 {
+		t.Header, t.Rows = records[0], records[1:]
+	}
+	return t, nil
+}
+
+// WriteCSV writes the table, including its header, as CSV data with comma as the field delimiter.
+func (t *Table) WriteCSV(w io.Writer, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	if len(t.Header) > 0 {
+		if err := cw.Write(t.Header); err != nil {
+			return err
+		}
+	}
+	if err := cw.WriteAll(t.Rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// columnName returns the name of a column, or an empty string if it has none.
+func (t *Table) columnName(column int) string {
+	if column < len(t.Header) {
+		return t.Header[column]
+	}
+	return ""
+}
+
+// ScanTableRequest is the request struct to scan a table with ScanTable.
+type ScanTableRequest struct {
+	Table *Table
+	// Policy and PolicyUUIDs select the policy the table is scanned with, as in a ScanTextRequest.
+	Policy      *Config
+	PolicyUUIDs []string
+	// Columns restricts the scan to the columns with these names, which must all be in the table's header. All
+	// columns are scanned if it is empty.
+	Columns []string
+	// Redact returns a copy of the table in which cells with findings are replaced by their redacted version. The
+	// policy must configure a redaction.
+	Redact bool
+}
+
+// ScanTableResponse is the response object returned by ScanTable.
+type ScanTableResponse struct {
+	// Findings are the findings in the table, ordered by row and column.
+	Findings []*TableFinding
+	// Columns summarizes the findings of every scanned column.
+	Columns []*ColumnSummary
+	// RedactedTable is the redacted copy of the table, if it was requested.
+	RedactedTable *Table
+}
+
+// TableFinding is a finding in a cell of a table. The finding's RowRange and ColumnRange locate the cell, ranging
+// from its zero-based row and column index to the next one; its other locations are relative to the cell.
+type TableFinding struct {
+	*Finding
+	Row        int
+	Column     int
+	ColumnName string
+}
+
+// ColumnSummary summarizes the findings in a column of a table.
+type ColumnSummary struct {
+	Column int
+	Name   string
+	// Cells is the number of cells scanned, excluding empty cells.
+	Cells int
+	// CellsWithFindings is the number of cells with at least one finding.
+	CellsWithFindings int
+	// Findings is the number of findings in the column.
+	Findings int
+	// Detectors counts the findings in the column by detector name.
+	Detectors map[string]int
+}
+
+// tableCell is a cell of a table.
+type tableCell struct {
+	row, column int
+}
+
+// ScanTable scans the cells of a table, each non-empty cell being a payload item of a ScanText call, and reports
+// the row and column of the findings.
+func (c *Client) ScanTable(ctx context.Context, request *ScanTableRequest) (*ScanTableResponse, error) {
+	if request.Table == nil {
+		return nil, errMissingTable
+	}
+	if request.Redact && len(request.PolicyUUIDs) == 0 && !redacts(request.Policy) {
+		return nil, errMissingRedactionConfig
+	}
+	table := request.Table
+
+	if len(request.Columns) > 0 && len(table.Header) == 0 {
+		return nil, errMissingHeader
+	}
+	known := map[string]bool{}
+	for _, name := range table.Header {
+		known[name] = true
+	}
+	var unknown []string
+	scanned := map[string]bool{}
+	for _, name := range request.Columns {
+		if !known[name] {
+			unknown = append(unknown, strconv.Quote(name))
+		}
+		scanned[name] = true
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("%w %s", errUnknownColumns, strings.Join(unknown, ", "))
+	}
+	summaries := map[int]*ColumnSummary{}
+	result := &ScanTableResponse{}
+
+	var payload []string
+	var cells []tableCell
+	for row, record := range table.Rows {
+		for column, value := range record {
+			name := table.columnName(column)
+			if len(scanned) > 0 && !scanned[name] {
+				continue
+			}
+			summary, ok := summaries[column]
+			if !ok {
+				summary = &ColumnSummary{Column: column, Name: name, Detectors: map[string]int{}}
+				summaries[column] = summary
+			}
+			if value == "" {
+				continue
+			}
+			summary.Cells++
+			payload = append(payload, value)
+			cells = append(cells, tableCell{row: row, column: column})
+		}
+	}
+	for column := 0; len(result.Columns) < len(summaries); column++ {
+		if summary, ok := summaries[column]; ok {
+			result.Columns = append(result.Columns, summary)
+		}
+	}
+	if request.Redact {
+		result.RedactedTable = table.clone()
+	}
+	if len(payload) == 0 {
+		return result, nil
+	}
+
+	resp, err := c.ScanText(ctx, &ScanTextRequest{Payload: payload, Policy: request.Policy, PolicyUUIDs: request.PolicyUUIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, cell := range cells {
+		if i >= len(resp.Findings) || len(resp.Findings[i]) == 0 {
+			continue
+		}
+		summary := summaries[cell.column]
+		summary.CellsWithFindings++
+		for _, f := range resp.Findings[i] {
+			if f.Location == nil {
+				f.Location = &Location{}
+			}
+			f.Location.RowRange = &Range{Start: int64(cell.row), End: int64(cell.row + 1)}
+			f.Location.ColumnRange = &Range{Start: int64(cell.column), End: int64(cell.column + 1)}
+			result.Findings = append(result.Findings, &TableFinding{
+				Finding:    f,
+				Row:        cell.row,
+				Column:     cell.column,
+				ColumnName: summary.Name,
+			})
+			summary.Findings++
+			summary.Detectors[f.Detector.DisplayName]++
+		}
+		if result.RedactedTable != nil && i < len(resp.RedactedPayload) && resp.RedactedPayload[i] != "" {
+			result.RedactedTable.Rows[cell.row][cell.column] = resp.RedactedPayload[i]
+		}
+	}
+	return result, nil
+}
+
+// clone returns a deep copy of the table.
+func (t *Table) clone() *Table {
+	c := &Table{Header: append([]string(nil), t.Header...), Rows: make([][]string, len(t.Rows))}
+	for i, row := range t.Rows {
+		c.Rows[i] = append([]string(nil), row...)
+	}
+	return c
+}
@@ -0,0 +1,116 @@
+package nightfall
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScanTable(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	table, err := ReadCSVTable(strings.NewReader("name\tnote\tid\nalice\tmy secret\t1\nbob\t\t2\ncarol\tsecret too\t3\tsecret extra\n"), '\t', true)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	resp, err := client.ScanTable(context.Background(), &ScanTableRequest{Table: table, Policy: testRedactionPolicy, Redact: true})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected cells to be scanned in a single request, got %d", calls)
+	}
+
+	expected := []struct {
+		row, column int
+		name        string
+	}{{0, 1, "note"}, {2, 1, "note"}, {2, 3, ""}}
+	if len(resp.Findings) != len(expected) {
+		t.Fatalf("Expected %d findings, got %d", len(expected), len(resp.Findings))
+	}
+	for i, exp := range expected {
+		f := resp.Findings[i]
+		if f.Row != exp.row || f.Column != exp.column || f.ColumnName != exp.name {
+			t.Errorf("Expected finding %d in row %d column %d (%q), got row %d column %d (%q)", i, exp.row, exp.column, exp.name, f.Row, f.Column, f.ColumnName)
+		}
+		if f.Location.RowRange.Start != int64(exp.row) || f.Location.ColumnRange.Start != int64(exp.column) {
+			t.Errorf("Unexpected location of finding %d: %+v %+v", i, f.Location.RowRange, f.Location.ColumnRange)
+		}
+	}
+
+	if len(resp.Columns) != 4 {
+		t.Fatalf("Expected 4 column summaries, got %d", len(resp.Columns))
+	}
+	note := resp.Columns[1]
+	if note.Name != "note" || note.Cells != 2 || note.CellsWithFindings != 2 || note.Findings != 2 {
+		t.Errorf("Unexpected summary of note column: %+v", note)
+	}
+	if name := resp.Columns[0]; name.Cells != 3 || name.Findings != 0 {
+		t.Errorf("Unexpected summary of name column: %+v", name)
+	}
+
+	var out bytes.Buffer
+	if err := resp.RedactedTable.WriteCSV(&out, ','); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expectedCSV := "name,note,id\nalice,[my secret],1\nbob,,2\ncarol,[secret too],3,[secret extra]\n"
+	if out.String() != expectedCSV {
+		t.Errorf("Expected redacted table:\n%s\ngot:\n%s", expectedCSV, out.String())
+	}
+	if table.Rows[0][1] != "my secret" {
+		t.Error("Expected original table to be unchanged")
+	}
+}
+
+func TestScanTableColumns(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(echoScanHandler(&calls, 0))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	table := &Table{Header: []string{"a", "b"}, Rows: [][]string{{"secret a", "secret b"}}}
+	resp, err := client.ScanTable(context.Background(), &ScanTableRequest{Table: table, Columns: []string{"b"}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if len(resp.Findings) != 1 || resp.Findings[0].ColumnName != "b" {
+		t.Errorf("Expected a single finding in column b, got %v", resp.Findings)
+	}
+	if len(resp.Columns) != 1 || resp.Columns[0].Column != 1 {
+		t.Errorf("Expected a summary of column b only, got %v", resp.Columns)
+	}
+	if resp.RedactedTable != nil {
+		t.Error("Expected no redacted table when not requested")
+	}
+
+	if _, err := client.ScanTable(context.Background(), &ScanTableRequest{}); !errors.Is(err, errMissingTable) {
+		t.Errorf("Expected missing table error, got %v", err)
+	}
+
+	_, err = client.ScanTable(context.Background(), &ScanTableRequest{Table: table, Columns: []string{"c", "b", "d"}})
+	if !errors.Is(err, errUnknownColumns) || !strings.Contains(err.Error(), `"c", "d"`) {
+		t.Errorf("Expected unknown columns error naming c and d, got %v", err)
+	}
+	_, err = client.ScanTable(context.Background(), &ScanTableRequest{Table: &Table{Rows: table.Rows}, Columns: []string{"a"}})
+	if !errors.Is(err, errMissingHeader) {
+		t.Errorf("Expected missing header error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected invalid requests not to be scanned, got %d requests", calls)
+	}
+}
@@ -77,16 +77,46 @@ type Range struct {
 // object will contain a list of lists representing the findings. Each index i in the findings array will
 // correspond one-to-one with the input request payload list, so all findings stored in a given sub-list refer to
 // matches that occurred in the ith index of the request payload.
+//
+// Payloads with more items or bytes than allowed in a single request are transparently split into several
+// requests, which are made concurrently; see OptionScanTextLimits and OptionScanTextConcurrency. The findings of
+// the combined response still align with the request payload.
+//
+// A single payload item too large to be scanned in one piece is split into overlapping windows; see
+// OptionScanTextWindow. Findings in such items are mapped back to their location in the item, and findings
+// reported by more than one window are only returned once.
+//
+// If the client was configured with OptionTimeout, the scan is cancelled once the timeout elapses.
 func (c *Client) ScanText(ctx context.Context, request *ScanTextRequest) (*ScanTextResponse, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	segments := c.segmentPayload(request.Payload)
+	batches := c.batchSegments(segments)
+	if len(batches) > 1 || len(segments) > len(request.Payload) {
+		return c.scanSegments(ctx, request, segments, batches)
+	}
+
+	return c.scanText(ctx, request)
+}
+
+// scanText scans the request with a single API request.
+func (c *Client) scanText(ctx context.Context, request *ScanTextRequest) (*ScanTextResponse, error) {
 	body, err := encodeBodyAsJSON(request)
 	if err != nil {
 		return nil, err
 	}
 	reqParams := requestParams{
-		method:  http.MethodPost,
-		url:     c.baseURL + "v3/scan",
-		body:    body,
-		headers: c.defaultHeaders(),
+		operation: OperationScanText,
+		method:    http.MethodPost,
+		url:       c.baseURL + "v3/scan",
+		body:      body,
+		headers:   c.defaultHeaders(),
+		// Scanning text does not create any server-side resources, so a failed scan may safely be repeated
+		idempotent: true,
 	}
 
 	scanResponse := &ScanTextResponse{}
@@ -94,6 +124,15 @@ func (c *Client) ScanText(ctx context.Context, request *ScanTextRequest) (*ScanT
 	if err != nil {
 		return nil, err
 	}
+	c.instrumentation.FindingsReturned(ctx, OperationScanText, scanResponse.numFindings())
 
 	return scanResponse, nil
 }
+
+func (r *ScanTextResponse) numFindings() int {
+	var n int
+	for _, findings := range r.Findings {
+		n += len(findings)
+	}
+	return n
+}
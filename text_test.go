@@ -31,11 +31,10 @@ func TestScanText(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 	defer s.Close()
 
-	client, err := NewClient(OptionAPIKey("some key"))
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionRetryPolicy(testRetryPolicy))
 	if err != nil {
 		t.Fatal("Error initializing client")
 	}
-	client.baseURL = s.URL + "/"
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -0,0 +1,191 @@
+package nightfall
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultScanTextWindowOverlap is the default number of bytes shared by consecutive windows of a payload item that
+// is too large to be scanned in one piece. Findings shorter than the overlap are never split across windows.
+const DefaultScanTextWindowOverlap = 1024
+
+var errInvalidScanTextWindow = errors.New("window must hold at least one rune and be larger than twice the overlap")
+
+// OptionScanTextWindow sets how ScanText splits a single payload item that is too large to be scanned in one
+// piece. Such items are scanned as overlapping windows of at most windowBytes once JSON-encoded, consecutive
+// windows sharing overlapBytes. If windowBytes is zero, windows are as large as the limit set by OptionScanTextLimits allows.
+func OptionScanTextWindow(windowBytes, overlapBytes int) func(*Client) error {
+	return func(c *Client) error {
+		if windowBytes < 0 || overlapBytes < 0 || (windowBytes > 0 && (windowBytes < utf8.UTFMax || windowBytes <= 2*overlapBytes)) {
+			return errInvalidScanTextWindow
+		}
+		c.scanTextWindowBytes = windowBytes
+		c.scanTextWindowOverlap = overlapBytes
+		return nil
+	}
+}
+
+// windowSize returns the maximum JSON-encoded size of a single segment of a payload item.
+func (c *Client) windowSize() int {
+	window := c.maxScanTextBytes - payloadItemOverhead
+	if c.scanTextWindowBytes > 0 && c.scanTextWindowBytes < window {
+		window = c.scanTextWindowBytes
+	}
+	return window
+}
+
+// segmentPayload turns the payload into segments, splitting items larger than the window size into overlapping
+// windows.
+func (c *Client) segmentPayload(payload []string) []textSegment {
+	window := c.windowSize()
+	overlap := c.scanTextWindowOverlap
+	if overlap*2 >= window {
+		overlap = window / 4
+	}
+
+	segments := make([]textSegment, 0, len(payload))
+	for i, text := range payload {
+		if encodedLen(text) <= window {
+			segments = append(segments, textSegment{index: i, text: text})
+			continue
+		}
+		segments = append(segments, splitWindows(i, text, window, overlap)...)
+	}
+	return segments
+}
+
+// splitWindows splits text into windows of at most window bytes once JSON-encoded, consecutive windows sharing
+// about overlap bytes. Windows never split a UTF-8 encoded rune.
+func splitWindows(index int, text string, window, overlap int) []textSegment {
+	var segments []textSegment
+	var codepoints int64
+	start := 0
+	for {
+		end, size := start, 0
+		for end < len(text) {
+			r, n := utf8.DecodeRuneInString(text[end:])
+			runeSize := encodedRuneLen(r, n)
+			// A window too small for the rune at start still holds that whole rune, so that splitting progresses
+			if end > start && size+runeSize > window {
+				break
+			}
+			end += n
+			size += runeSize
+		}
+		segments = append(segments, textSegment{
+			index:           index,
+			text:            text[start:end],
+			byteOffset:      int64(start),
+			codepointOffset: codepoints,
+		})
+		if end == len(text) {
+			return segments
+		}
+
+		next := end - overlap
+		for next > start && !utf8.RuneStart(text[next]) {
+			next--
+		}
+		if next <= start {
+			next = end
+		}
+		codepoints += int64(utf8.RuneCountInString(text[start:next]))
+		start = next
+	}
+}
+
+// remapFinding shifts the location of a finding in a segment to its location in the original payload item.
+func remapFinding(f *Finding, seg textSegment) {
+	if f.Location == nil || (seg.byteOffset == 0 && seg.codepointOffset == 0) {
+		return
+	}
+	if r := f.Location.ByteRange; r != nil {
+		f.Location.ByteRange = &Range{Start: r.Start + seg.byteOffset, End: r.End + seg.byteOffset}
+	}
+	if r := f.Location.CodepointRange; r != nil {
+		f.Location.CodepointRange = &Range{Start: r.Start + seg.codepointOffset, End: r.End + seg.codepointOffset}
+	}
+}
+
+// mergeWindowFindings removes duplicate findings reported by overlapping windows of a payload item. When findings
+// of the same detector overlap, only the longest is kept, since the others were cut off at a window boundary.
+// The returned findings are ordered by location.
+func mergeWindowFindings(findings []*Finding) []*Finding {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findingStart(findings[i]) < findingStart(findings[j])
+	})
+
+	merged := make([]*Finding, 0, len(findings))
+	for _, f := range findings {
+		duplicate := false
+		for i, kept := range merged {
+			if kept.Detector != f.Detector || !findingsOverlap(kept, f) {
+				continue
+			}
+			duplicate = true
+			if findingLen(f) > findingLen(kept) {
+				merged[i] = f
+			}
+			break
+		}
+		if !duplicate {
+			merged = append(merged, f)
+		}
+	}
+	return merged
+}
+
+// redactWindowed rebuilds the redacted version of a payload item that was scanned in windows, by replacing every
+// finding with its redacted form. The redacted locations of the findings are updated to match.
+func redactWindowed(text string, findings []*Finding) string {
+	var sb strings.Builder
+	var pos int64
+	var codepoints int64
+	for _, f := range findings {
+		if f.RedactedFinding == "" || f.Location == nil || f.Location.ByteRange == nil {
+			continue
+		}
+		r := f.Location.ByteRange
+		if r.Start < pos || r.End > int64(len(text)) || r.Start > r.End {
+			// Overlaps a finding that was already redacted
+			continue
+		}
+		sb.WriteString(text[pos:r.Start])
+		codepoints += int64(utf8.RuneCountInString(text[pos:r.Start]))
+
+		redactedStart := int64(sb.Len())
+		redactedCodepoints := int64(utf8.RuneCountInString(f.RedactedFinding))
+		sb.WriteString(f.RedactedFinding)
+		f.RedactedLocation = &Location{
+			ByteRange:      &Range{Start: redactedStart, End: int64(sb.Len())},
+			CodepointRange: &Range{Start: codepoints, End: codepoints + redactedCodepoints},
+		}
+		codepoints += redactedCodepoints
+		pos = r.End
+	}
+	sb.WriteString(text[pos:])
+	return sb.String()
+}
+
+func findingStart(f *Finding) int64 {
+	if f.Location == nil || f.Location.ByteRange == nil {
+		return 0
+	}
+	return f.Location.ByteRange.Start
+}
+
+func findingLen(f *Finding) int64 {
+	if f.Location == nil || f.Location.ByteRange == nil {
+		return 0
+	}
+	return f.Location.ByteRange.End - f.Location.ByteRange.Start
+}
+
+func findingsOverlap(a, b *Finding) bool {
+	if a.Location == nil || a.Location.ByteRange == nil || b.Location == nil || b.Location.ByteRange == nil {
+		return false
+	}
+	return a.Location.ByteRange.Start < b.Location.ByteRange.End && b.Location.ByteRange.Start < a.Location.ByteRange.End
+}
@@ -0,0 +1,177 @@
+package nightfall
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// tokenScanHandler reports every occurrence of token in the payload as a finding, and redacts it as "[REDACTED]".
+func tokenScanHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &ScanTextRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp := &ScanTextResponse{
+			Findings:        make([][]*Finding, len(req.Payload)),
+			RedactedPayload: make([]string, len(req.Payload)),
+		}
+		for i, item := range req.Payload {
+			for pos := 0; ; {
+				n := strings.Index(item[pos:], token)
+				if n < 0 {
+					break
+				}
+				start := pos + n
+				resp.Findings[i] = append(resp.Findings[i], &Finding{
+					Finding:         token,
+					RedactedFinding: "[REDACTED]",
+					Detector:        DetectorMetadata{DisplayName: "token"},
+					Location: &Location{
+						ByteRange:      &Range{Start: int64(start), End: int64(start + len(token))},
+						CodepointRange: &Range{Start: int64(utf8.RuneCountInString(item[:start])), End: int64(utf8.RuneCountInString(item[:start+len(token)]))},
+					},
+				})
+				pos = start + len(token)
+			}
+			if len(resp.Findings[i]) > 0 {
+				resp.RedactedPayload[i] = strings.ReplaceAll(item, token, "[REDACTED]")
+			}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func TestScanTextWindows(t *testing.T) {
+	s := httptest.NewServer(tokenScanHandler("token-1234"))
+	defer s.Close()
+
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionScanTextWindow(64, 16))
+	if err != nil {
+		t.Fatal("Error initializing client")
+	}
+
+	item := strings.Repeat("é", 25) + "token-1234" + strings.Repeat("x", 100) + "token-1234" + strings.Repeat("ü", 50)
+	resp, err := client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{"short", item}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	findings := resp.Findings[1]
+	if len(findings) != 2 {
+		t.Fatalf("Expected 2 findings, got %d", len(findings))
+	}
+	for _, f := range findings {
+		r := f.Location.ByteRange
+		if item[r.Start:r.End] != "token-1234" {
+			t.Errorf("Byte range %d-%d does not locate the finding, got %q", r.Start, r.End, item[r.Start:r.End])
+		}
+		cr := f.Location.CodepointRange
+		if string([]rune(item)[cr.Start:cr.End]) != "token-1234" {
+			t.Errorf("Codepoint range %d-%d does not locate the finding", cr.Start, cr.End)
+		}
+	}
+
+	expected := strings.ReplaceAll(item, "token-1234", "[REDACTED]")
+	if resp.RedactedPayload[1] != expected {
+		t.Errorf("Expected redacted payload %q, got %q", expected, resp.RedactedPayload[1])
+	}
+	if resp.RedactedPayload[0] != "" {
+		t.Errorf("Expected no redacted payload for item without findings, got %q", resp.RedactedPayload[0])
+	}
+	for _, f := range findings {
+		r := f.RedactedLocation.ByteRange
+		if expected[r.Start:r.End] != "[REDACTED]" {
+			t.Errorf("Redacted byte range %d-%d does not locate the redacted finding", r.Start, r.End)
+		}
+	}
+}
+
+func TestSplitWindows(t *testing.T) {
+	text := strings.Repeat("aé漢", 30)
+	segments := splitWindows(3, text, 20, 5)
+	if len(segments) < 2 {
+		t.Fatalf("Expected several windows, got %d", len(segments))
+	}
+
+	end := 0
+	for i, seg := range segments {
+		if seg.index != 3 {
+			t.Errorf("Expected segment index 3, got %d", seg.index)
+		}
+		if len(seg.text) > 20 {
+			t.Errorf("Window %d exceeds the window size: %d bytes", i, len(seg.text))
+		}
+		if !utf8.ValidString(seg.text) {
+			t.Errorf("Window %d splits a rune", i)
+		}
+		if text[seg.byteOffset:int(seg.byteOffset)+len(seg.text)] != seg.text {
+			t.Errorf("Window %d does not match its byte offset", i)
+		}
+		if int(seg.codepointOffset) != utf8.RuneCountInString(text[:seg.byteOffset]) {
+			t.Errorf("Window %d has codepoint offset %d, expected %d", i, seg.codepointOffset, utf8.RuneCountInString(text[:seg.byteOffset]))
+		}
+		if i > 0 && int(seg.byteOffset) >= end {
+			t.Errorf("Window %d does not overlap the previous window", i)
+		}
+		end = int(seg.byteOffset) + len(seg.text)
+	}
+	if end != len(text) {
+		t.Errorf("Windows end at %d, expected %d", end, len(text))
+	}
+}
+
+func TestOptionScanTextWindow(t *testing.T) {
+	for _, tt := range []struct {
+		window, overlap int
+		wantErr         bool
+	}{
+		{window: 0, overlap: 0},
+		{window: 1024, overlap: 100},
+		{window: 100, overlap: 50, wantErr: true},
+		{window: -1, overlap: 0, wantErr: true},
+		{window: 100, overlap: -1, wantErr: true},
+	} {
+		_, err := NewClient(OptionAPIKey("some key"), OptionScanTextWindow(tt.window, tt.overlap))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("OptionScanTextWindow(%d, %d): got error %v, want error %v", tt.window, tt.overlap, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSplitWindowsProgress(t *testing.T) {
+	text := strings.Repeat("😀", 3)
+	segments := splitWindows(0, text, 3, 1)
+	if len(segments) != 3 {
+		t.Fatalf("Expected one window per rune, got %d", len(segments))
+	}
+	for i, seg := range segments {
+		if seg.text != "😀" || seg.byteOffset != int64(4*i) || seg.codepointOffset != int64(i) {
+			t.Errorf("Unexpected window %d: %+v", i, seg)
+		}
+	}
+}
+
+func TestScanTextWindowLimits(t *testing.T) {
+	for _, opt := range []ClientOption{OptionScanTextLimits(10, 3), OptionScanTextLimits(10, payloadItemOverhead+utf8.UTFMax), OptionScanTextWindow(3, 1)} {
+		if _, err := NewClient(OptionAPIKey("some key"), opt); err == nil {
+			t.Error("Expected limits too small to hold a rune to be rejected")
+		}
+	}
+
+	s := httptest.NewServer(tokenScanHandler("token"))
+	defer s.Close()
+	client, err := NewClient(OptionAPIKey("some key"), OptionBaseURL(s.URL), OptionScanTextLimits(10, payloadItemOverhead+utf8.UTFMax+1), OptionScanTextWindow(4, 1))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if _, err := client.ScanText(context.Background(), &ScanTextRequest{Payload: []string{strings.Repeat("😀", 3)}}); err != nil {
+		t.Errorf("Got unexpected error: %v", err)
+	}
+}